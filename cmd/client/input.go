@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"unicode"
+
+	tea "charm.land/bubbletea/v2"
+	types "github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+const (
+	inputPollInterval = 10 * time.Millisecond
+	// Without key release events a key counts as held until it stops
+	// repeating. Has to be longer than the delay before the terminal starts
+	// repeating, otherwise movement stutters.
+	keyHoldTimeout = 500 * time.Millisecond
+	// Shooting fires on press, a short hold lets quick taps fire separately
+	shootHoldTimeout = 80 * time.Millisecond
+)
+
+type keyBinding struct {
+	actions types.InputAction
+	aim     types.Direction
+	aims    bool
+}
+
+var keyBindings = map[rune]keyBinding{
+	tea.KeyUp:    {types.IA_UP, types.D_UP, true},
+	'k':          {types.IA_UP, types.D_UP, true},
+	tea.KeyDown:  {types.IA_DOWN, types.D_DOWN, true},
+	'j':          {types.IA_DOWN, types.D_DOWN, true},
+	tea.KeyLeft:  {types.IA_LEFT, types.D_LEFT, true},
+	'h':          {types.IA_LEFT, types.D_LEFT, true},
+	tea.KeyRight: {types.IA_RIGHT, types.D_RIGHT, true},
+	'l':          {types.IA_RIGHT, types.D_RIGHT, true},
+	'e':          {types.IA_SHOOT, 0, false},
+}
+
+type heldKey struct {
+	actions types.InputAction
+	// Zero if the key is held until its release event
+	until time.Time
+	// Reported by state at least once, a key tapped between two polls
+	// still counts as held for one of them
+	seen bool
+}
+
+// inputTracker keeps the keys the player is holding. Key events come from
+// the UI, the input state is read by pollInput.
+type inputTracker struct {
+	mu            sync.Mutex
+	releaseEvents bool
+	held          map[rune]heldKey
+	aim           types.Direction
+}
+
+func newInputTracker() *inputTracker {
+	return &inputTracker{held: map[rune]heldKey{}, aim: types.D_RIGHT}
+}
+
+// keyCode gives the same code for a key with and without shift, so that
+// the release of "l" matches the press of "L".
+func keyCode(k tea.Key) (rune, bool) {
+	code := unicode.ToLower(k.Code)
+	shifted := k.Mod.Contains(tea.ModShift) || code != k.Code
+	return code, shifted
+}
+
+func (it *inputTracker) setReleaseEvents(supported bool) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.releaseEvents = supported
+}
+
+// press returns false for keys that are not game controls.
+func (it *inputTracker) press(k tea.Key) bool {
+	code, shifted := keyCode(k)
+	binding, ok := keyBindings[code]
+	if !ok {
+		return false
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	key := heldKey{actions: binding.actions}
+	if shifted {
+		key.actions |= types.IA_RUN
+	}
+	if !it.releaseEvents {
+		timeout := keyHoldTimeout
+		if binding.actions == types.IA_SHOOT {
+			timeout = shootHoldTimeout
+		}
+		key.until = time.Now().Add(timeout)
+	}
+	it.held[code] = key
+	if binding.aims {
+		it.aim = binding.aim
+	}
+	return true
+}
+
+func (it *inputTracker) release(k tea.Key) bool {
+	code, _ := keyCode(k)
+	if _, ok := keyBindings[code]; !ok {
+		return false
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	key, ok := it.held[code]
+	if ok && !key.seen {
+		key.until = time.Now()
+		it.held[code] = key
+		return true
+	}
+	delete(it.held, code)
+	return true
+}
+
+// releaseAll drops all held keys, e.g. when the player starts typing.
+func (it *inputTracker) releaseAll() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	clear(it.held)
+}
+
+// state returns the actions held at the moment, keys that timed out are
+// released.
+func (it *inputTracker) state(now time.Time) types.InputCommand {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	actions := types.InputAction(0)
+	for code, key := range it.held {
+		if key.seen && !key.until.IsZero() && now.After(key.until) {
+			delete(it.held, code)
+			continue
+		}
+		actions |= key.actions
+		key.seen = true
+		it.held[code] = key
+	}
+	return types.InputCommand{Actions: actions, Aim: it.aim}
+}
@@ -1,23 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
-	"net"
+	"math"
 	"os"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	// "net/http"
 	// _ "net/http/pprof"
 
 	tea "charm.land/bubbletea/v2"
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/transport"
 	types "github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
 )
 
 const (
 	defaultServerAddress = "localhost:8000"
 	mapObjRenderChar     = '#'
+	triggerRenderChar    = '░'
+	udpScheme            = "udp://"
+	handshakeTimeout     = 10 * time.Second
+	stateHistorySize     = 32
+	eventFeedSize        = 5
 )
 
 type model struct {
@@ -26,34 +36,52 @@ type model struct {
 
 type Connection struct {
 	gameStateChan <-chan *types.GameState
-	commandsChan  chan<- types.Command
+	inputChan     chan<- types.InputCommand
+	errChan       <-chan error
+	eventsChan    <-chan types.GameEvents
+	chatChan      chan<- string
+	// Sequence of the last input sent to the server
+	lastInputSequence *atomic.Uint32
+}
+
+type connectionLostMsg struct {
+	err error
 }
 
 func initialModel(conn Connection, initData types.InitializationData) model {
 	return model{
 		game: &LocalGame{
-			field_x:        types.FieldMaxX,
-			field_y:        types.FieldMaxY,
+			field_x:        int(initData.FieldMaxX),
+			field_y:        int(initData.FieldMaxY),
 			emptyFiledRune: ' ',
 			playerID:       initData.PlayerID,
 			connection:     conn,
-			mapObjects:     types.MapObjects,
+			mapObjects:     initData.MapObjects,
 			keysPressed:    0,
+			input:          newInputTracker(),
 		},
 	}
 }
 
 func (m model) Init() tea.Cmd {
-	return receiveState(m.game.connection.gameStateChan)
+	return tea.Batch(receiveState(m.game.connection), receiveEvents(m.game.connection))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case *types.GameState:
 		m.game.currentState = msg
-		return m, receiveState(m.game.connection.gameStateChan)
+		return m, receiveState(m.game.connection)
+
+	case types.GameEvents:
+		m.game.addEvents(msg)
+		return m, receiveEvents(m.game.connection)
 
-	case tea.KeyMsg:
+	case connectionLostMsg:
+		m.game.connectionErr = msg.err
+		return m, tea.Quit
+
+	case tea.KeyPressMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return m, tea.Quit
@@ -64,11 +92,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m model) View() tea.View {
 	v := tea.NewView(m.game.Render())
+	// Lets us know when a key is released instead of guessing from repeats
+	v.KeyboardEnhancements.ReportEventTypes = true
 	prevRender = time.Now()
 	return v
 }
 
-func receiveState(gamestateChan <-chan *types.GameState) tea.Cmd {
+func receiveState(conn Connection) tea.Cmd {
 	return func() tea.Msg {
 		sleepDur := 20 * time.Millisecond
 		var state *types.GameState
@@ -77,11 +107,11 @@ func receiveState(gamestateChan <-chan *types.GameState) tea.Cmd {
 
 		for {
 			select {
-			case s, ok := <-gamestateChan:
-				if ok {
-					state = s
-					gotState = true
-				}
+			case s := <-conn.gameStateChan:
+				state = s
+				gotState = true
+			case err := <-conn.errChan:
+				return connectionLostMsg{err}
 			case <-t.C:
 				if gotState {
 					return state
@@ -91,6 +121,12 @@ func receiveState(gamestateChan <-chan *types.GameState) tea.Cmd {
 	}
 }
 
+func receiveEvents(conn Connection) tea.Cmd {
+	return func() tea.Msg {
+		return <-conn.eventsChan
+	}
+}
+
 var prevRender time.Time = time.Now()
 var maxrenderms int64 = 0
 
@@ -103,6 +139,53 @@ type LocalGame struct {
 	playerID       types.ObjectID
 	mapObjects     []types.MapObject
 	keysPressed    int
+	connectionErr  error
+	input          *inputTracker
+	eventFeed      []string
+	chatting       bool
+	chatInput      []rune
+}
+
+// printable drops control characters, text from other players must not
+// mess with the terminal.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func (g *LocalGame) addEvents(events types.GameEvents) {
+	for _, event := range events {
+		g.eventFeed = append(g.eventFeed, printable(event.ToString()))
+	}
+	g.eventFeed = g.eventFeed[max(0, len(g.eventFeed)-eventFeedSize):]
+}
+
+// handleChatKey edits the chat line, enter sends it and esc drops it.
+func (g *LocalGame) handleChatKey(msg tea.KeyPressMsg) {
+	switch msg.String() {
+	case "enter":
+		text := strings.TrimSpace(string(g.chatInput))
+		if text != "" {
+			g.connection.chatChan <- text
+		}
+		g.chatting = false
+		g.chatInput = nil
+	case "esc":
+		g.chatting = false
+		g.chatInput = nil
+	case "backspace":
+		if len(g.chatInput) > 0 {
+			g.chatInput = g.chatInput[:len(g.chatInput)-1]
+		}
+	default:
+		if len(string(g.chatInput))+len(msg.Text) <= types.MaxChatLength {
+			g.chatInput = append(g.chatInput, []rune(printable(msg.Text))...)
+		}
+	}
 }
 
 func (g *LocalGame) getInterfaceRow() string {
@@ -122,10 +205,12 @@ func (g *LocalGame) getInterfaceRow() string {
 	interfaceString := "INTERFACE HERE"
 	localPlyaer, exists := g.currentState.Players[g.playerID]
 	if exists {
-		interfaceString = fmt.Sprintf("HP: %d, Coords: %s, Keys pressed: %d ",
+		sentInputs := g.connection.lastInputSequence.Load()
+		interfaceString = fmt.Sprintf("HP: %d, Coords: %s, Keys pressed: %d, Inputs in flight: %d ",
 			localPlyaer.HP,
 			localPlyaer.Position.ToString(),
 			g.keysPressed,
+			sentInputs-min(sentInputs, localPlyaer.LastInput),
 		)
 	}
 	return fmt.Sprintf("%s%s", debugInfo, interfaceString)
@@ -144,18 +229,18 @@ func (g *LocalGame) Render() string {
 
 	if g.currentState != nil {
 		for _, p := range g.currentState.Players {
-			x := int(p.Position.X)
-			y := int(p.Position.Y)
-			if x < 0 || x > g.field_x || y < 0 || y > g.field_y {
+			x := int(math.Round(p.Position.X))
+			y := int(math.Round(p.Position.Y))
+			if x < 0 || x >= g.field_x || y < 0 || y >= g.field_y {
 				continue
 			}
 			field[y][x] = p.ViewDirection.AsRune()
 		}
 
 		for _, p := range g.currentState.Projectiles {
-			x := int(p.Position.X)
-			y := int(p.Position.Y)
-			if x < 0 || x > g.field_x || y < 0 || y > g.field_y {
+			x := int(math.Round(p.Position.X))
+			y := int(math.Round(p.Position.Y))
+			if x < 0 || x >= g.field_x || y < 0 || y >= g.field_y {
 				continue
 			}
 			field[y][x] = p.Rune
@@ -166,11 +251,24 @@ func (g *LocalGame) Render() string {
 		if !mo.IsVisible {
 			continue
 		}
+		renderChar := mapObjRenderChar
+		if mo.IsTrigger {
+			renderChar = triggerRenderChar
+		}
+		// Players can be inside of objects they pass through, those are
+		// only the background
+		background := !mo.Blocks(types.CL_PLAYER)
 		cb := mo.GetCollisionBox()
 		for y := cb.BottomLeft.Y; y < cb.TopRight.Y; y++ {
 			for x := cb.BottomLeft.X; x < cb.TopRight.X; x++ {
+				if x < 0 || int(x) >= g.field_x || y < 0 || int(y) >= g.field_y {
+					continue
+				}
+				if background && field[int32(y)][int32(x)] != g.emptyFiledRune {
+					continue
+				}
 				// TODO: textures?
-				field[int32(y)][int32(x)] = mapObjRenderChar
+				field[int32(y)][int32(x)] = renderChar
 			}
 		}
 	}
@@ -182,42 +280,192 @@ func (g *LocalGame) Render() string {
 	}
 	ir := g.getInterfaceRow()
 	res[len(res)-1] = ir + fmt.Sprintf(" FRT: %dms", time.Since(renderStartTime).Milliseconds())
+	res = append(res, g.eventFeed...)
+	if g.chatting {
+		res = append(res, "Say: "+string(g.chatInput)+"_")
+	}
 	return strings.Join(res, "\n")
 }
 
-func (g *LocalGame) SendCommand(direction types.Command) {
-	g.connection.commandsChan <- direction
+// pollInput sends the input state to the server whenever it changes.
+func (g *LocalGame) pollInput() {
+	last := g.input.state(time.Now())
+	for now := range time.Tick(inputPollInterval) {
+		input := g.input.state(now)
+		if input == last {
+			continue
+		}
+		g.connection.inputChan <- input
+		last = input
+	}
 }
 
-func connectToServer(serverAddress string) (Connection, types.InitializationData) {
-	conn, err := net.Dial("tcp", serverAddress)
+func connectToServer(serverAddress string, playerName string) (Connection, types.InitializationData) {
+	var conn transport.Conn
+	var err error
+	if udpAddress, ok := strings.CutPrefix(serverAddress, udpScheme); ok {
+		conn, err = transport.DialUDP(udpAddress)
+	} else {
+		conn, err = transport.DialTCP(serverAddress)
+	}
 	if err != nil {
 		fmt.Println("Error connecting:", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("Connected to", serverAddress)
-	initializationData := types.InitializationDataFromBytes(conn)
+	hello := types.ClientHello{
+		ProtocolVersion: types.ProtocolVersion,
+		BuildID:         types.BuildID,
+		PlayerName:      playerName,
+		Compressions:    types.SupportedCompressions,
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	err = conn.WriteMessage(hello.ToMessage())
+	if err != nil {
+		fmt.Println("Error during handshake:", err)
+		os.Exit(1)
+	}
+
+	initMsg, err := conn.ReadMessage()
+	if err != nil {
+		fmt.Println("Error during handshake:", err)
+		os.Exit(1)
+	}
+	if initMsg.Type == types.MT_REJECT {
+		rejection, err := types.RejectionFromBytes(bytes.NewReader(initMsg.Payload))
+		if err != nil {
+			fmt.Println("Server rejected connection:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Server rejected connection:", rejection.ToString())
+		os.Exit(1)
+	}
+	if initMsg.Version != types.ProtocolVersion || initMsg.Type != types.MT_INITIALIZATION_DATA {
+		fmt.Printf("Error during handshake: unexpected message %s (protocol v%d)\n", initMsg.Type.ToString(), initMsg.Version)
+		os.Exit(1)
+	}
+	initializationData, err := types.InitializationDataFromBytes(bytes.NewReader(initMsg.Payload))
+	if err != nil {
+		fmt.Println("Error during handshake:", err)
+		os.Exit(1)
+	}
+	conn.SetReadDeadline(time.Time{})
 
 	gameStateChannel := make(chan *types.GameState, 128)
+	controlChannel := make(chan types.Message, 128)
+	errChannel := make(chan error, 1)
+	eventsChannel := make(chan types.GameEvents, 128)
+	reportError := func(err error) {
+		select {
+		case errChannel <- err:
+		default:
+		}
+		conn.Close()
+	}
+
+	// Newest tick known to the client, inputs are meant for the one after it
+	latestTick := atomic.Uint64{}
+
 	go func() {
+		history := map[types.GameTick]types.GameState{}
+		awaitingFullState := false
 		for {
-			gs := types.GameStateFromBytes(conn)
+			msg, err := conn.ReadMessage()
+			if err == nil {
+				msg, err = types.Decompress(msg)
+			}
+			if err != nil {
+				reportError(err)
+				return
+			}
+
+			var gs types.GameState
+			switch msg.Type {
+			case types.MT_GAME_STATE:
+				gs, err = types.GameStateFromBytes(bytes.NewReader(msg.Payload))
+				if err != nil {
+					reportError(err)
+					return
+				}
+				awaitingFullState = false
+			case types.MT_GAME_STATE_DELTA:
+				delta, err := types.GameStateDeltaFromBytes(bytes.NewReader(msg.Payload))
+				if err != nil {
+					reportError(err)
+					return
+				}
+				base, ok := history[delta.BaseTick]
+				if !ok {
+					// Desync, nothing to apply delta to
+					if !awaitingFullState {
+						controlChannel <- types.NewMessage(types.MT_FULL_STATE_REQUEST, nil)
+						awaitingFullState = true
+					}
+					continue
+				}
+				gs, err = delta.ApplyTo(base)
+				if err != nil {
+					reportError(err)
+					return
+				}
+			case types.MT_EVENTS:
+				events, err := types.GameEventsFromBytes(bytes.NewReader(msg.Payload))
+				if err != nil {
+					reportError(err)
+					return
+				}
+				eventsChannel <- events
+				continue
+			default:
+				continue
+			}
+
+			history[gs.TickNumber] = gs
+			for tick := range history {
+				if tick+stateHistorySize <= gs.TickNumber {
+					delete(history, tick)
+				}
+			}
+			if uint64(gs.TickNumber) > latestTick.Load() {
+				latestTick.Store(uint64(gs.TickNumber))
+			}
+			controlChannel <- types.NewMessage(types.MT_ACK, gs.TickNumber.ToBytes())
 			gameStateChannel <- &gs
 		}
 	}()
 
-	commandChannel := make(chan types.Command, 128)
+	inputChannel := make(chan types.InputCommand, 128)
+	chatChannel := make(chan string, 16)
+	lastInputSequence := &atomic.Uint32{}
 	go func() {
-		for cmd := range commandChannel {
-			_, err := conn.Write([]byte{byte(cmd)})
+		for {
+			var msg types.Message
+			select {
+			case input := <-inputChannel:
+				input.Sequence = lastInputSequence.Add(1)
+				input.Tick = types.GameTick(latestTick.Load() + 1)
+				msg = input.ToMessage()
+			case text := <-chatChannel:
+				msg = types.ChatMessage{Text: text}.ToMessage()
+			case msg = <-controlChannel:
+			}
+			err := conn.WriteMessage(msg)
 			if err != nil {
-				panic(err)
+				reportError(err)
+				return
 			}
 		}
 	}()
 
-	return Connection{gameStateChannel, commandChannel}, initializationData
+	return Connection{
+		gameStateChannel,
+		inputChannel,
+		errChannel,
+		eventsChannel,
+		chatChannel,
+		lastInputSequence,
+	}, initializationData
 }
 
 // We don't want to render on controls (user movement, etc), because we
@@ -230,31 +478,32 @@ func controlsFilter(m tea.Model, msg tea.Msg) tea.Msg {
 	mdl := m.(model)
 
 	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "shift+right", "L":
-			mdl.game.SendCommand(types.RIGHT_RUN)
-			return nil
-		case "shift+left", "H":
-			mdl.game.SendCommand(types.LEFT_RUN)
-			return nil
-		case "up", "k", "shift+up", "K":
-			mdl.game.SendCommand(types.UP)
-			return nil
-		case "down", "j", "shift+down", "J":
-			mdl.game.SendCommand(types.DOWN)
-			return nil
-		case "left", "h":
-			mdl.game.SendCommand(types.LEFT)
+	case tea.KeyboardEnhancementsMsg:
+		mdl.game.input.setReleaseEvents(msg.SupportsEventTypes())
+		return nil
+	case tea.KeyPressMsg:
+		if mdl.game.chatting {
+			if msg.String() == "ctrl+c" {
+				return msg
+			}
+			mdl.game.handleChatKey(msg)
 			return nil
-		case "right", "l":
-			mdl.game.SendCommand(types.RIGHT)
+		}
+		if msg.String() == "t" {
+			mdl.game.input.releaseAll()
+			mdl.game.chatting = true
 			return nil
-		case "e":
+		}
+		if !mdl.game.input.press(msg.Key()) {
+			return msg
+		}
+		if msg.String() == "e" && !msg.IsRepeat {
 			mdl.game.keysPressed++
-			mdl.game.SendCommand(types.SHOOT)
-			return nil
 		}
+		return nil
+	case tea.KeyReleaseMsg:
+		mdl.game.input.release(tea.Key(msg))
+		return nil
 	}
 	return msg
 }
@@ -264,14 +513,24 @@ func main() {
 	// 	http.ListenAndServe("localhost:6060", nil)
 	// }()
 
+	playerName := flag.String("name", os.Getenv("USER"), "display name of the player")
+	flag.Parse()
+
 	serverAddress := defaultServerAddress
-	if len(os.Args) > 1 {
-		serverAddress = os.Args[1]
+	if flag.NArg() > 0 {
+		serverAddress = flag.Arg(0)
 	}
-	conn, initData := connectToServer(serverAddress)
-	p := tea.NewProgram(initialModel(conn, initData), tea.WithFilter(controlsFilter))
-	if _, err := p.Run(); err != nil {
+	conn, initData := connectToServer(serverAddress, *playerName)
+	m := initialModel(conn, initData)
+	go m.game.pollInput()
+	p := tea.NewProgram(m, tea.WithFilter(controlsFilter))
+	finalModel, err := p.Run()
+	if err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
+	if err := finalModel.(model).game.connectionErr; err != nil {
+		fmt.Println("Connection lost:", err)
+		os.Exit(1)
+	}
 }
@@ -72,6 +72,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					Position:      types.Vector{X: minx, Y: miny},
 					CollisionArea: types.CollisionArea{X: maxx - minx, Y: maxy - miny},
 					IsVisible:     true,
+					Mask:          types.CL_ALL,
 				})
 				m.wallInitPoint = nil
 			}
@@ -1,25 +1,49 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	tea "charm.land/bubbletea/v2"
 	server "github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/server"
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/simulation"
 	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
 )
 
+const mapPath = "map.json"
+
+// MyLogBuffer keeps the server logs for the TUI. The game engine writes
+// from several goroutines while the TUI reads.
 type MyLogBuffer struct {
-	Logs []string
+	mu   sync.Mutex
+	logs []string
 }
 
 func (b *MyLogBuffer) WriteString(s string) (n int, err error) {
-	b.Logs = append(b.Logs, s)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logs = append(b.logs, s)
 	return len(s), nil
 }
 
+func (b *MyLogBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.logs)
+}
+
+// Last returns up to n newest logs.
+func (b *MyLogBuffer) Last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.logs[max(0, len(b.logs)-n):])
+}
+
 type model struct {
 	logBuffer *MyLogBuffer
 	ge        *server.GameEngine
@@ -37,7 +61,7 @@ func interfaceTimer(logBuffer *MyLogBuffer) tea.Cmd {
 		sleepDur := 200 * time.Millisecond
 		t := time.NewTicker(sleepDur)
 		for range t.C {
-			if len(logBuffer.Logs) > 0 {
+			if logBuffer.Len() > 0 {
 				return InterfaceUpdate(1)
 			}
 		}
@@ -68,11 +92,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func getInterfaceString(gameState *types.GameState) string {
+func getSnapshotStatsString(ge *server.GameEngine) string {
+	raw, sent := ge.SnapshotStats()
+	ratio := 100.0
+	if raw > 0 {
+		ratio = float64(sent) / float64(raw) * 100
+	}
+	return fmt.Sprintf("Snapshots: %d KiB raw, %d KiB sent (%.1f%%, %s)\n",
+		raw/1024, sent/1024, ratio, ge.Compression.ToString())
+}
+
+func getPhysicsString(ge *server.GameEngine) string {
+	return fmt.Sprintf("Physics: %s\n", ge.Physics().ToString())
+}
+
+func getInterfaceString(gameState types.GameState) string {
 	playerInfo := []string{"Players:"}
 	for _, player := range gameState.Players {
-		playerInfo = append(playerInfo, fmt.Sprintf("ID: %v %v HP: %v",
-			player.ID, player.Position.ToString(), player.HP))
+		playerInfo = append(playerInfo, fmt.Sprintf("ID: %v %q %v HP: %v",
+			player.ID, player.Name, player.Position.ToString(), player.HP))
 	}
 	res := fmt.Sprintf("Tick: %d\n", gameState.TickNumber)
 	res += strings.Join(playerInfo, "\n")
@@ -81,23 +119,77 @@ func getInterfaceString(gameState *types.GameState) string {
 }
 
 func (m model) View() tea.View {
-	serverInterface := getInterfaceString(&m.ge.State)
-	logs := strings.Join(m.logBuffer.Logs[max(0, len(m.logBuffer.Logs)-5):len(m.logBuffer.Logs)], "\n")
+	serverInterface := getSnapshotStatsString(m.ge) + getPhysicsString(m.ge) + getInterfaceString(m.ge.Snapshot())
+	logs := strings.Join(m.logBuffer.Last(5), "\n")
 	serverInterface = fmt.Sprintf("%v\nLogs:\n%v", serverInterface, logs)
 	return tea.NewView(serverInterface)
 }
 
 func main() {
-	port := ""
+	maxPlayers := flag.Int("max-players", server.DefaultMaxPlayers, "maximum number of players on the server")
+	bannedNames := flag.String("ban", "", "comma separated list of banned player names")
+	udpPort := flag.String("udp-port", "", "port for UDP clients, same as the TCP port if empty")
+	wsAddr := flag.String("ws-addr", "", "address for WebSocket clients, e.g. :8080, disabled if empty")
+	wsOrigins := flag.String("ws-origin", "", "comma separated list of origins of other hosts allowed to open WebSockets, * for any")
+	compressionName := flag.String("compression", "deflate", "snapshot compression offered to clients: deflate or none")
+	allowJSON := flag.Bool("json", false, "accept newline delimited JSON clients on the TCP port, for debugging")
+	tickRate := flag.Int("tick-rate", server.DefaultTickRate, "simulation ticks per second")
+	seed := flag.Int64("seed", 0, "seed of the match randomness, random if 0")
+	physicsPath := flag.String("physics", "", "physics profile JSON, reloaded when it changes; built-in physics if empty")
+	flag.Parse()
+	port := flag.Arg(0)
+	if *udpPort == "" {
+		*udpPort = port
+	}
+	if *tickRate > simulation.MaxTickRate {
+		fmt.Printf("Error: tick rate %d is above %d\n", *tickRate, simulation.MaxTickRate)
+		os.Exit(1)
+	}
+	compression, err := types.ParseCompression(*compressionName)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	mapObjects, err := types.LoadMapObjects(mapPath)
+	if err != nil {
+		fmt.Println("Error loading map:", err)
+		os.Exit(1)
+	}
 
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	physics := simulation.DefaultPhysics()
+	if *physicsPath != "" {
+		physics, err = simulation.LoadPhysics(*physicsPath)
+		if err != nil {
+			fmt.Println("Error loading physics:", err)
+			os.Exit(1)
+		}
 	}
 
 	logBuffer := &MyLogBuffer{}
-	ge := server.RunGameEngine(logBuffer)
+	ge := server.RunGameEngine(logBuffer, mapObjects, simulation.Config{TickRate: *tickRate, Seed: *seed, Physics: physics})
+	ge.MaxPlayers = *maxPlayers
+	ge.Compression = compression
+	ge.AllowJSON = *allowJSON
+	for _, origin := range strings.Split(*wsOrigins, ",") {
+		if origin != "" {
+			ge.AllowedOrigins = append(ge.AllowedOrigins, origin)
+		}
+	}
+	for _, name := range strings.Split(*bannedNames, ",") {
+		if name != "" {
+			ge.BannedNames[name] = true
+		}
+	}
 	m := initialModel(ge, logBuffer)
 	go server.RunServer(port, ge)
+	go server.RunUDPServer(*udpPort, ge)
+	if *physicsPath != "" {
+		go server.WatchPhysics(*physicsPath, ge)
+	}
+	if *wsAddr != "" {
+		go server.RunWebSocketServer(*wsAddr, ge)
+	}
 	if _, err := tea.NewProgram(m).Run(); err != nil {
 		fmt.Println("Error running program:", err)
 		os.Exit(1)
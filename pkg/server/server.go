@@ -1,407 +1,554 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"math"
-	"math/rand"
-	"net"
+	"os"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/simulation"
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/transport"
 	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
 )
 
 const (
-	gameTick                = 40 * time.Millisecond
-	defaultPort             = "8000"
-	XSLOW                   = 0.1
-	YSLOW                   = 0.1
-	MAX_X_SPEED             = 1
-	FRICTION_BOUNDARY       = 0.7
-	PLAYER_X_SPEED_INC_RUN  = 1.7
-	PLAYER_X_SPEED_INC_STEP = 0.7
-	PLAYER_Y_SPEED_INC      = 2
-	GRAVITY_SPEED_INC       = 0.2
+	DefaultTickRate     = simulation.DefaultTickRate
+	maxCatchUpTicks     = 5
+	snapshotHistorySize = 32
+	// Clients that can't keep up with snapshots for this long are dropped
+	maxClientLag      = time.Second
+	helloTimeout      = 5 * time.Second
+	DefaultMaxPlayers = 16
+	WebSocketPath     = "/ws"
+	defaultPort       = "8000"
+	// How often WatchPhysics checks the physics profile for changes
+	physicsPollInterval = time.Second
 )
 
-type ClinetConn struct {
-	write chan<- types.GameState
+// snapshot is what every client gets for a tick: the state and the events
+// that happened since the previous one. It is shared by all connections
+// and never changes, each message is encoded at most once.
+type snapshot struct {
+	state types.GameState
+	// nil if nothing happened
+	events *types.Message
+
+	mu       sync.Mutex
+	messages map[snapshotKey]encodedSnapshot
 }
 
-type engineCommand struct {
-	playerID types.ObjectID
-	command  types.Command
+type snapshotKey struct {
+	// Zero for the full state
+	baseTick    types.GameTick
+	compression types.Compression
 }
 
-type GameEngine struct {
-	newPlayerID     types.ObjectID
-	newProjectileID types.ObjectID
-	playerCommands  []engineCommand
+type encodedSnapshot struct {
+	msg types.Message
+	// Payload size before compression
+	rawSize int
+}
+
+func newSnapshot(state types.GameState, events types.GameEvents) *snapshot {
+	snap := &snapshot{state: state, messages: map[snapshotKey]encodedSnapshot{}}
+	if len(events) > 0 {
+		msg := events.ToMessage()
+		snap.events = &msg
+	}
+	return snap
+}
+
+// message encodes the state either as a delta against base, or as a full
+// snapshot if base is nil. Clients with the same base and compression get
+// the same bytes.
+func (snap *snapshot) message(base *snapshot, compressor *types.Compressor) encodedSnapshot {
+	key := snapshotKey{compression: compressor.Compression()}
+	if base != nil {
+		key.baseTick = base.state.TickNumber
+	}
+
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if encoded, ok := snap.messages[key]; ok {
+		return encoded
+	}
+	msg := snap.state.ToMessage()
+	if base != nil {
+		msg = types.DiffGameState(base.state, snap.state).ToMessage()
+	}
+	encoded := encodedSnapshot{msg: compressor.Compress(msg), rawSize: len(msg.Payload)}
+	snap.messages[key] = encoded
+	return encoded
+}
 
-	conns       map[types.ObjectID]*ClinetConn
-	State       types.GameState
-	engineInput chan engineCommand
+// newestSnapshot skips snapshots that are already stale in the queue, only
+// the newest one is worth sending. Events of the skipped ones are returned
+// along with its own, so that none get lost.
+func newestSnapshot(snap *snapshot, queue <-chan *snapshot) (*snapshot, []types.Message) {
+	events := []types.Message{}
+	for {
+		if snap.events != nil {
+			events = append(events, *snap.events)
+		}
+		select {
+		case next, ok := <-queue:
+			if !ok {
+				return snap, events
+			}
+			snap = next
+		default:
+			return snap, events
+		}
+	}
+}
+
+type ClinetConn struct {
+	conn transport.Conn
+	// Closed when the client is dropped, guarded by GameEngine.mu
+	queue chan *snapshot
+
+	lastAckedTick      atomic.Uint64
+	fullStateRequested atomic.Bool
+}
+
+// GameEngine runs the simulation in real time and connects it with the
+// clients.
+type GameEngine struct {
+	// All guarded by mu
+	sim   *simulation.Simulation
+	conns map[types.ObjectID]*ClinetConn
+	// Recent snapshots that clients may diff against
+	history map[types.GameTick]*snapshot
 
 	mu sync.Mutex
 
+	MaxPlayers  int
+	BannedNames map[string]bool
+	// Compression is used for clients that support it
+	Compression types.Compression
+	// AllowJSON lets TCP clients use the JSON debug protocol
+	AllowJSON bool
+	// Origins of browser pages on other hosts that may open a WebSocket
+	AllowedOrigins []string
+
+	rawSnapshotBytes  atomic.Uint64
+	sentSnapshotBytes atomic.Uint64
+
 	LogWriter io.StringWriter
 }
 
-func (ge *GameEngine) addPlayer(conn *ClinetConn) types.ObjectID {
+func (ge *GameEngine) addPlayer(conn *ClinetConn, name string) (types.ObjectID, bool) {
 	ge.mu.Lock()
 	defer ge.mu.Unlock()
 
-	newID := ge.newPlayerID
-	ge.newPlayerID++
-	ge.conns[newID] = conn
-	ge.State.Players[newID] = &types.Player{
-		ID:            newID,
-		ViewDirection: types.D_RIGHT,
-		Position: types.Vector{
-			X: float64(len(ge.State.Players)),
-			Y: float64(len(ge.State.Players)),
-		},
-		CollisionArea: types.CollisionArea{X: 0.9, Y: 0.9},
-		HP:            5,
-	}
-	return newID
+	if ge.sim.PlayerCount() >= ge.MaxPlayers {
+		return 0, false
+	}
+	playerID := ge.sim.AddPlayer(name)
+	ge.conns[playerID] = conn
+	return playerID, true
 }
 
-func (ge *GameEngine) AddProjectile(position types.Vector, speed types.Vector) {
+func (ge *GameEngine) disconnectPlayer(playerID types.ObjectID) {
 	ge.mu.Lock()
 	defer ge.mu.Unlock()
 
-	newID := ge.newProjectileID
-	ge.newProjectileID++
-	ge.State.Projectiles[newID] = &types.Projectile{
-		ID:            newID,
-		Rune:          '•',
-		Position:      position,
-		Speed:         speed,
-		CollisionArea: types.CollisionArea{X: 1, Y: 1},
+	// Both reader and writer of the connection may get here
+	ge.sim.RemovePlayer(playerID)
+	ge.dropConnLocked(playerID)
+}
+
+// dropConnLocked stops sending snapshots to the client and closes the
+// connection, which also unblocks a writer stuck on a slow client. Must be
+// called with mu held.
+func (ge *GameEngine) dropConnLocked(playerID types.ObjectID) {
+	cliConn, ok := ge.conns[playerID]
+	if !ok {
+		return
+	}
+	close(cliConn.queue)
+	cliConn.conn.Close()
+	delete(ge.conns, playerID)
+}
+
+// finishConnLocked stops sending snapshots to the client, the writer
+// closes the connection after sending the ones already queued. Must be
+// called with mu held.
+func (ge *GameEngine) finishConnLocked(playerID types.ObjectID) {
+	cliConn, ok := ge.conns[playerID]
+	if !ok {
+		return
+	}
+	close(cliConn.queue)
+	delete(ge.conns, playerID)
+}
+
+// deltaBase returns the snapshot acknowledged by the client, nil if the
+// client needs the full state.
+func (ge *GameEngine) deltaBase(cliConn *ClinetConn) *snapshot {
+	if cliConn.fullStateRequested.Swap(false) {
+		return nil
 	}
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+	return ge.history[types.GameTick(cliConn.lastAckedTick.Load())]
 }
 
-func (ge *GameEngine) removeProjectile(projectileID types.ObjectID) {
+// Snapshot returns a copy of the current game state.
+func (ge *GameEngine) Snapshot() types.GameState {
 	ge.mu.Lock()
 	defer ge.mu.Unlock()
+	return ge.sim.Snapshot()
+}
 
-	delete(ge.State.Projectiles, projectileID)
+func (ge *GameEngine) Physics() simulation.Physics {
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+	return ge.sim.Physics()
 }
 
-func (ge *GameEngine) disconnectPlayer(playerID types.ObjectID) {
+// SetPhysics changes the physics between ticks.
+func (ge *GameEngine) SetPhysics(physics simulation.Physics) {
 	ge.mu.Lock()
 	defer ge.mu.Unlock()
+	ge.sim.SetPhysics(physics)
+}
 
-	delete(ge.State.Players, playerID)
-	delete(ge.conns, playerID)
+// readHello waits for the client hello and checks whether the client is
+// allowed to join. Returned rejection is nil if the client is welcome.
+func (ge *GameEngine) readHello(conn transport.Conn) (types.ClientHello, *types.Rejection) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		return types.ClientHello{}, &types.Rejection{Reason: types.RR_BAD_HELLO, Details: err.Error()}
+	}
+	if msg.Type != types.MT_CLIENT_HELLO {
+		return types.ClientHello{}, &types.Rejection{
+			Reason:  types.RR_BAD_HELLO,
+			Details: fmt.Sprintf("expected %s, got %s", types.MT_CLIENT_HELLO.ToString(), msg.Type.ToString()),
+		}
+	}
 
+	// Checked before decoding, hello of other versions may have other fields
+	if msg.Version != types.ProtocolVersion {
+		return types.ClientHello{}, &types.Rejection{
+			Reason:  types.RR_VERSION_MISMATCH,
+			Details: fmt.Sprintf("server speaks protocol v%d, client v%d", types.ProtocolVersion, msg.Version),
+		}
+	}
+	hello, err := types.ClientHelloFromBytes(bytes.NewReader(msg.Payload))
+	if err != nil {
+		return types.ClientHello{}, &types.Rejection{Reason: types.RR_BAD_HELLO, Details: err.Error()}
+	}
+	if hello.ProtocolVersion != types.ProtocolVersion {
+		return hello, &types.Rejection{
+			Reason:  types.RR_VERSION_MISMATCH,
+			Details: fmt.Sprintf("server speaks protocol v%d, client v%d", types.ProtocolVersion, hello.ProtocolVersion),
+		}
+	}
+	if len(hello.PlayerName) > types.MaxPlayerNameLength {
+		return hello, &types.Rejection{
+			Reason:  types.RR_BAD_HELLO,
+			Details: fmt.Sprintf("player name is longer than %d bytes", types.MaxPlayerNameLength),
+		}
+	}
+	if ge.BannedNames[hello.PlayerName] {
+		return hello, &types.Rejection{Reason: types.RR_BANNED}
+	}
+	return hello, nil
 }
 
-func (ge *GameEngine) HandleConnection(conn net.Conn) {
+// negotiateCompression picks the server compression if the client can
+// decode it, no compression otherwise. Compressed messages have no JSON
+// form, JSON clients never get them.
+func (ge *GameEngine) negotiateCompression(conn transport.Conn, hello types.ClientHello) types.Compression {
+	if transport.IsJSON(conn) {
+		return types.C_NONE
+	}
+	if slices.Contains(hello.Compressions, ge.Compression) {
+		return ge.Compression
+	}
+	return types.C_NONE
+}
+
+// SnapshotStats returns the total size of snapshot payloads before and
+// after compression.
+func (ge *GameEngine) SnapshotStats() (raw uint64, sent uint64) {
+	return ge.rawSnapshotBytes.Load(), ge.sentSnapshotBytes.Load()
+}
+
+func (ge *GameEngine) reject(conn transport.Conn, rejection types.Rejection) {
+	ge.Log(fmt.Sprintf("Rejected %s: %s", conn.RemoteAddr(), rejection.ToString()))
+	conn.WriteMessage(rejection.ToMessage())
+	conn.Close()
+}
+
+func (ge *GameEngine) HandleConnection(conn transport.Conn) {
 	//fmt.Printf("New connection: %v\n", conn)
-	write := make(chan types.GameState)
-	cliConn := &ClinetConn{write}
-	playerID := ge.addPlayer(cliConn)
-	initData := types.InitializationData{PlayerID: playerID}
-	_, err := conn.Write(initData.ToBytes())
+	hello, rejection := ge.readHello(conn)
+	if rejection != nil {
+		ge.reject(conn, *rejection)
+		return
+	}
+
+	// Tick duration never changes, it is fine to read without the lock
+	queueSize := max(1, int(maxClientLag/ge.sim.TickDuration()))
+	cliConn := &ClinetConn{conn: conn, queue: make(chan *snapshot, queueSize)}
+	cliConn.fullStateRequested.Store(true)
+	playerID, ok := ge.addPlayer(cliConn, hello.PlayerName)
+	if !ok {
+		ge.reject(conn, types.Rejection{
+			Reason:  types.RR_SERVER_FULL,
+			Details: fmt.Sprintf("%d players max", ge.MaxPlayers),
+		})
+		return
+	}
+	compression := ge.negotiateCompression(conn, hello)
+	ge.Log(fmt.Sprintf("Player %d %q joined (build %s, compression %s)",
+		playerID, hello.PlayerName, hello.BuildID, compression.ToString()))
+
+	initData := types.InitializationData{
+		PlayerID:    playerID,
+		FieldMaxX:   types.FieldMaxX,
+		FieldMaxY:   types.FieldMaxY,
+		MapObjects:  ge.sim.MapObjects(),
+		Compression: compression,
+	}
+	err := conn.WriteMessage(initData.ToMessage())
 	if err != nil {
 		ge.disconnectPlayer(playerID)
 		return
 	}
 
 	go func() {
-		for state := range write {
-			_, err := conn.Write(state.ToBytes())
+		compressor := types.NewCompressor(compression)
+		for snap := range cliConn.queue {
+			snap, events := newestSnapshot(snap, cliConn.queue)
+			encoded := snap.message(ge.deltaBase(cliConn), compressor)
+			ge.rawSnapshotBytes.Add(uint64(encoded.rawSize))
+			ge.sentSnapshotBytes.Add(uint64(len(encoded.msg.Payload)))
+
+			err := conn.WriteMessage(encoded.msg)
+			for _, msg := range events {
+				if err == nil {
+					err = conn.WriteMessage(msg)
+				}
+			}
 			if err != nil {
+				ge.Log(fmt.Sprintf("Player %d disconnected, sending failed: %v", playerID, err))
 				ge.disconnectPlayer(playerID)
 				return
 			}
 		}
+		// The queue is closed and drained, there won't be anything else
+		conn.Close()
 	}()
 
 	go func() {
 		for {
-			buff := make([]byte, 1)
-			_, err := io.ReadFull(conn, buff)
-			if err == io.EOF {
-				continue
+			msg, err := conn.ReadMessage()
+			if err == nil {
+				err = ge.handleClientMessage(playerID, cliConn, msg)
 			}
 			if err != nil {
+				ge.Log(fmt.Sprintf("Player %d disconnected: %v", playerID, err))
 				ge.disconnectPlayer(playerID)
+				conn.Close()
 				return
 			}
-			ge.engineInput <- engineCommand{command: types.Command(buff[0]), playerID: playerID}
 		}
 	}()
 }
 
-func (ge *GameEngine) detectCollision(
-	selfID types.ObjectID,
-	currentBox types.CollisionBox,
-	movement types.Vector,
-) types.CollidableObject {
-	possibleCollisionBox := currentBox.Add(movement)
-
-	//fmt.Printf("Possible collision box: %s\n", possibleCollisionBox.ToString())
-	//fmt.Printf("Last possible collision box: %s\n", currentBox.ToString())
-	//fmt.Printf("Movment vector: %+v\n", movement.ToString())
-
-	for _, mo := range ge.State.MapObjects {
-		moCollisionBox := mo.CollisionArea.ToCollisionBox(mo.Position)
-		if moCollisionBox.IntersectsWith(possibleCollisionBox) {
-			return &mo
-		}
+func (ge *GameEngine) handleClientMessage(playerID types.ObjectID, cliConn *ClinetConn, msg types.Message) error {
+	if msg.Version != types.ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", msg.Version)
 	}
-
-	for _, p := range ge.State.Players {
-		if p.ID == selfID {
-			continue
+	switch msg.Type {
+	case types.MT_COMMAND:
+		input, err := types.InputCommandFromBytes(bytes.NewReader(msg.Payload))
+		if err != nil {
+			return err
+		}
+		ge.mu.Lock()
+		ge.sim.ApplyInput(playerID, input)
+		ge.mu.Unlock()
+	case types.MT_ACK:
+		tick := types.GameTick(0)
+		err := tick.FillFromBytes(bytes.NewReader(msg.Payload))
+		if err != nil {
+			return fmt.Errorf("decoding ack: %w", err)
 		}
-		playerCollisionBox := p.CollisionArea.ToCollisionBox(p.Position)
-		if playerCollisionBox.IntersectsWith(possibleCollisionBox) {
-			return p
+		cliConn.lastAckedTick.Store(uint64(tick))
+	case types.MT_FULL_STATE_REQUEST:
+		cliConn.fullStateRequested.Store(true)
+	case types.MT_CHAT:
+		chat, err := types.ChatMessageFromBytes(bytes.NewReader(msg.Payload))
+		if err != nil {
+			return err
 		}
+		ge.Log(fmt.Sprintf("Player %d says: %q", playerID, chat.Text))
+		ge.mu.Lock()
+		ge.sim.Chat(playerID, chat.Text)
+		ge.mu.Unlock()
 	}
 	return nil
 }
 
-func getSpeedsAfterCollision(
-	current types.CollisionBox,
-	speed types.Vector,
-	stepVector types.Vector,
-	collidesWith types.CollidableObject,
-) (types.Vector, types.Vector) {
-	collidedWithBox := collidesWith.GetCollisionArea().ToCollisionBox(collidesWith.GetPosition())
-	if collidedWithBox.IntersectsWithX(current) {
-		// Already was within X bounds, meaning collision happend during Y movement
-		//fmt.Printf("* Y Collision detected with %s\n", collidedWithBox.ToString())
-		speed.Y = 0
-		stepVector.Y = 0
-	} else if collidedWithBox.IntersectsWithY(current) {
-		// Already was within Y bounds, meaning collision happend during X movement
-		//fmt.Printf("* X Collision detected with %s\n", collidedWithBox.ToString())
-		speed.X = 0
-		stepVector.X = 0
-	} else {
-		// Diagonal collision
-		speed.X = 0
-		speed.Y = 0
-		stepVector.X = 0
-		stepVector.Y = 0
-		//fmt.Printf("Diagonal collision with %s\n", collidedWithBox.ToString())
-	}
-	return speed, stepVector
-}
-
-func getStepVectorWithIterations(v types.Vector) (types.Vector, int) {
-	singleVector := v.SingleVector()
-	maxIterations := int32(math.Round(v.GetLen()))
-	if maxIterations < 4 {
-		singleVector = v.Multiply(0.1)
-		maxIterations = maxIterations * 10
-	}
-	return singleVector, int(maxIterations)
+func (ge *GameEngine) Log(s string) {
+	ge.LogWriter.WriteString(s)
 }
 
-func (ge *GameEngine) MoveObject(obj types.MovableObject) types.CollidableObject {
-	speed := obj.GetSpeed()
-	stepVector, maxIterations := getStepVectorWithIterations(speed)
-
-	lastPossibleCollisionBox := obj.GetCollisionArea().ToCollisionBox(obj.GetPosition())
+// Run simulates the game with a fixed timestep. Time that passed since the
+// last wake up is simulated in whole ticks, so a late tick is caught up
+// instead of lost. Snapshot is sent once the simulation is up to date.
+func (ge *GameEngine) Run() {
+	tick := ge.sim.TickDuration()
+	ticker := time.NewTicker(tick)
+
+	accumulator := time.Duration(0)
+	last := time.Now()
+	for now := range ticker.C {
+		elapsed := now.Sub(last)
+		last = now
+		accumulator += elapsed
+		// A tick or two of timer jitter is caught up silently
+		if elapsed >= 2*tick {
+			ge.Log(fmt.Sprintf("Tick overrun: %dms since the previous tick", elapsed.Milliseconds()))
+		}
+		if accumulator > maxCatchUpTicks*tick {
+			ge.Log(fmt.Sprintf("Tick overrun: dropping %d ticks", (accumulator-maxCatchUpTicks*tick)/tick))
+			accumulator = maxCatchUpTicks * tick
+		}
 
-	var collidesWith types.CollidableObject = nil
-	for range maxIterations {
-		possibleCollision := ge.detectCollision(obj.GetID(), lastPossibleCollisionBox, stepVector)
-		if possibleCollision == nil {
-			lastPossibleCollisionBox = lastPossibleCollisionBox.Add(stepVector)
+		if accumulator < tick {
 			continue
 		}
 
-		collidesWith = possibleCollision
-
-		speed, stepVector = getSpeedsAfterCollision(
-			lastPossibleCollisionBox,
-			speed,
-			stepVector,
-			collidesWith,
-		)
-
-		possibleCollision = ge.detectCollision(obj.GetID(), lastPossibleCollisionBox, stepVector)
-		if possibleCollision == nil {
-			lastPossibleCollisionBox = lastPossibleCollisionBox.Add(stepVector)
-			continue
+		ge.mu.Lock()
+		for accumulator >= tick {
+			ge.sim.Step()
+			accumulator -= tick
 		}
-		collidesWith = possibleCollision
-
-		speed, stepVector = getSpeedsAfterCollision(
-			lastPossibleCollisionBox,
-			speed,
-			stepVector,
-			collidesWith,
-		)
-	}
-	obj.SetSpeed(speed)
-	obj.SetPosition(lastPossibleCollisionBox.BottomLeft)
-	return collidesWith
+		ge.broadcastLocked(newSnapshot(ge.sim.Snapshot(), ge.sim.TakeEvents()))
+		ge.mu.Unlock()
+	}
 }
 
-func (ge *GameEngine) calculateState() {
-	for _, player := range ge.State.Players {
-		// "gravity"
-		player.Speed.Y -= GRAVITY_SPEED_INC
-
-		//fmt.Printf("%s\n", player.ToString())
-
-		ge.MoveObject(player)
-
-		if player.HP == 0 {
-			ge.disconnectPlayer(player.ID)
-			continue
+// broadcastLocked queues the snapshot for every client without waiting for
+// any of them. Must be called with mu held.
+func (ge *GameEngine) broadcastLocked(snap *snapshot) {
+	tickNumber := snap.state.TickNumber
+	ge.history[tickNumber] = snap
+	for tick := range ge.history {
+		if tick+snapshotHistorySize <= tickNumber {
+			delete(ge.history, tick)
 		}
+	}
 
-		// "slowing"
-		// TODO: airborn not working now
-		newSpeed := types.Vector{}
-		if player.Speed.X > 0 {
-			slowX := math.Pow(player.Speed.X, 2) * XSLOW
-			newSpeed.X = player.Speed.X - slowX
-			if player.Speed.X < FRICTION_BOUNDARY && !player.IsAirborn {
-				newSpeed.X = 0
-			}
-		}
-		if player.Speed.X < 0 {
-			slowX := math.Pow(player.Speed.X, 2) * XSLOW
-			newSpeed.X = player.Speed.X - -slowX
-			if player.Speed.X > -FRICTION_BOUNDARY && !player.IsAirborn {
-				newSpeed.X = 0
+	for playerID, cliConn := range ge.conns {
+		// Players that died are gone from the game, so are their
+		// connections once they got the snapshot with their death
+		if _, ok := snap.state.Players[playerID]; !ok {
+			select {
+			case cliConn.queue <- snap:
+				ge.finishConnLocked(playerID)
+			default:
+				ge.dropConnLocked(playerID)
 			}
+			continue
 		}
-		if player.Speed.Y > 0 {
-			slowY := math.Pow(player.Speed.Y, 2) * YSLOW
-			newSpeed.Y = player.Speed.Y - slowY
-		}
-		if player.Speed.Y < 0 {
-			slowY := math.Pow(player.Speed.Y, 2) * YSLOW
-			newSpeed.Y = player.Speed.Y - -slowY
-		}
-
-		player.Speed = newSpeed
-	}
-
-	for _, proj := range ge.State.Projectiles {
-		//fmt.Printf("Projectile %s\n", proj.Position.ToString())
-		collidesWith := ge.MoveObject(proj)
-		if collidesWith != nil {
-			//fmt.Printf("Collides with: %v\n", collidesWith)
-			collidesWith.OnCollision(proj)
-			ge.removeProjectile(proj.ID)
+		select {
+		case cliConn.queue <- snap:
+		default:
+			ge.Log(fmt.Sprintf("Player %d is more than %v behind, disconnecting", playerID, maxClientLag))
+			ge.sim.RemovePlayer(playerID)
+			ge.dropConnLocked(playerID)
 		}
 	}
 }
 
-func (ge *GameEngine) saveCommand(cmd engineCommand) {
-	ge.mu.Lock()
-	defer ge.mu.Unlock()
-	ge.playerCommands = append(ge.playerCommands, cmd)
-}
-
-func (ge *GameEngine) applyCommands() {
-	ge.mu.Lock()
-	commandsToApply := slices.Clone(ge.playerCommands)
-	clear(ge.playerCommands)
-	ge.mu.Unlock()
-
-	for _, c := range commandsToApply {
-		ge.applyCommand(c)
+// RunGameEngine starts the simulation, see simulation.Config for defaults.
+// The seed is logged so that the match can be replayed.
+func RunGameEngine(stringWriter io.StringWriter, mapObjects []types.MapObject, config simulation.Config) *GameEngine {
+	ge := &GameEngine{
+		sim:         simulation.New(mapObjects, config),
+		conns:       map[types.ObjectID]*ClinetConn{},
+		history:     map[types.GameTick]*snapshot{},
+		MaxPlayers:  DefaultMaxPlayers,
+		BannedNames: map[string]bool{},
+		Compression: types.C_DEFLATE,
+		LogWriter:   stringWriter,
 	}
+	ge.Log(fmt.Sprintf("Match seed: %d, tick rate: %d", ge.sim.Seed(), ge.sim.TickRate()))
+	go ge.Run()
+	return ge
 }
 
-func (ge *GameEngine) applyCommand(cmd engineCommand) {
-	player, ok := ge.State.Players[cmd.playerID]
-	if !ok {
-		return
+// WatchPhysics reloads the physics profile whenever the file changes. A
+// profile that fails to load is logged and the old physics stay.
+func WatchPhysics(path string, ge *GameEngine) {
+	lastModTime := time.Time{}
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
 	}
-	switch cmd.command {
-	case types.UP:
-		player.Speed = player.Speed.Add(types.Vector{X: 0, Y: PLAYER_Y_SPEED_INC})
-		player.ViewDirection = types.D_UP
-	case types.DOWN:
-		player.Speed = player.Speed.Add(types.Vector{X: 0, Y: -PLAYER_Y_SPEED_INC})
-		player.ViewDirection = types.D_DOWN
-	case types.RIGHT:
-		player.Speed = player.Speed.Add(types.Vector{X: PLAYER_X_SPEED_INC_STEP, Y: 0})
-		player.ViewDirection = types.D_RIGHT
-	case types.LEFT:
-		player.Speed = player.Speed.Add(types.Vector{X: -PLAYER_X_SPEED_INC_STEP, Y: 0})
-		player.ViewDirection = types.D_LEFT
-	case types.LEFT_RUN:
-		if player.Speed.X < -MAX_X_SPEED {
-			break
+	for range time.Tick(physicsPollInterval) {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(lastModTime) {
+			continue
 		}
-		player.Speed = player.Speed.Add(types.Vector{X: -PLAYER_X_SPEED_INC_RUN, Y: 0})
-		player.ViewDirection = types.D_LEFT
-	case types.RIGHT_RUN:
-		if player.Speed.X > MAX_X_SPEED {
-			break
+		lastModTime = info.ModTime()
+
+		physics, err := simulation.LoadPhysics(path)
+		if err != nil {
+			ge.Log(fmt.Sprintf("Error reloading physics: %v", err))
+			continue
 		}
-		player.Speed = player.Speed.Add(types.Vector{X: PLAYER_X_SPEED_INC_RUN, Y: 0})
-		player.ViewDirection = types.D_RIGHT
-	case types.SHOOT:
-		ge.AddProjectile(
-			player.Position.Add(player.ViewDirection.AsVector()),
-			player.ViewDirection.AsVector().Multiply(2.0).Add(types.Vector{
-				X: 0,
-				Y: rand.Float64() - 0.5,
-			}),
-		)
+		ge.SetPhysics(physics)
+		ge.Log(fmt.Sprintf("Physics reloaded from %s", path))
 	}
 }
 
-func (ge *GameEngine) Log(s string) {
-	ge.LogWriter.WriteString(s)
-}
-
-func (ge *GameEngine) Run() {
-	ticker := time.NewTicker(gameTick)
-	go func() {
-		for ec := range ge.engineInput {
-			//fmt.Printf("new command: %+v\n", ec)
-			ge.saveCommand(ec)
+func (ge *GameEngine) serve(listener transport.Listener) {
+	ge.Log(fmt.Sprintf("Running on %s", listener.Addr()))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			panic(err)
 		}
-	}()
 
-	t := time.Now()
-	for range ticker.C {
-		ge.State.TickNumber++
-		ge.Log(fmt.Sprintf("elapsed: %d", time.Since(t).Milliseconds()))
-		ge.applyCommands()
-		ge.calculateState()
-		for _, cli := range ge.conns {
-			cli.write <- ge.State
-		}
-		t = time.Now()
+		go ge.HandleConnection(conn)
 	}
 }
 
-func RunGameEngine(stringWriter io.StringWriter) *GameEngine {
-	ge := &GameEngine{
-		State: types.GameState{
-			Players:     types.PlayerMap{},
-			Projectiles: types.ProjectileMap{},
-			MapObjects:  types.MapObjects,
-		},
-		conns:       map[types.ObjectID]*ClinetConn{},
-		engineInput: make(chan engineCommand),
-		LogWriter:   stringWriter,
+func RunServer(
+	port string,
+	ge *GameEngine,
+) {
+	if port == "" {
+		port = defaultPort
 	}
-	go ge.Run()
-	return ge
+
+	listen := transport.ListenTCP
+	if ge.AllowJSON {
+		listen = transport.ListenTCPWithJSON
+	}
+	listener, err := listen("0.0.0.0:" + port)
+	if err != nil {
+		panic(err)
+	}
+	ge.serve(listener)
 }
 
-func RunServer(
+// RunUDPServer accepts UDP clients, it can run next to RunServer with the
+// same port and engine.
+func RunUDPServer(
 	port string,
 	ge *GameEngine,
 ) {
@@ -409,20 +556,22 @@ func RunServer(
 		port = defaultPort
 	}
 
-	addr := "0.0.0.0:" + port
-
-	listner, err := net.Listen("tcp", addr)
+	listener, err := transport.ListenUDP("0.0.0.0:" + port)
 	if err != nil {
 		panic(err)
 	}
-	ge.LogWriter.WriteString(fmt.Sprintf("Running on %s", addr))
-
-	for {
-		conn, err := listner.Accept()
-		if err != nil {
-			panic(err)
-		}
+	ge.serve(listener)
+}
 
-		go ge.HandleConnection(conn)
+// RunWebSocketServer accepts WebSocket clients on addr at WebSocketPath.
+// They use the same framed binary protocol as TCP clients.
+func RunWebSocketServer(
+	addr string,
+	ge *GameEngine,
+) {
+	listener, err := transport.ListenWebSocket(addr, WebSocketPath, ge.AllowedOrigins)
+	if err != nil {
+		panic(err)
 	}
+	ge.serve(listener)
 }
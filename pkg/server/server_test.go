@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/simulation"
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/transport"
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+type discardLog struct{}
+
+func (discardLog) WriteString(s string) (int, error) {
+	return len(s), nil
+}
+
+func TestJSONClientGetsNoCompression(t *testing.T) {
+	ge := RunGameEngine(discardLog{}, nil, simulation.Config{Seed: 1})
+	ge.AllowJSON = true
+	listener, err := transport.ListenTCPWithJSON("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not closed, serve doesn't expect it
+	go ge.serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The client offers DEFLATE, which has no JSON form
+	fmt.Fprintf(conn, `{"type":"CLIENT_HELLO","payload":{"protocol_version":%d,"player_name":"nc","compressions":[%d]}}`+"\n",
+		types.ProtocolVersion, types.C_DEFLATE)
+
+	lines := bufio.NewScanner(conn)
+	lines.Buffer(nil, 1<<20)
+	readMessage := func() types.JSONMessage {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("connection ended: %v", lines.Err())
+		}
+		msg := types.JSONMessage{}
+		err := json.Unmarshal(lines.Bytes(), &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	msg := readMessage()
+	initData := types.InitializationData{}
+	err = json.Unmarshal(msg.Payload, &initData)
+	if msg.Type != types.MT_INITIALIZATION_DATA.ToString() || err != nil {
+		t.Fatalf("got %s %v, want initialization data", msg.Type, err)
+	}
+	if initData.Compression != types.C_NONE {
+		t.Fatalf("got compression %s, want none", initData.Compression.ToString())
+	}
+	// Snapshots keep coming, the connection is not dropped
+	for range 3 {
+		readMessage()
+	}
+}
+
+func TestDeadPlayerGetsOwnDeath(t *testing.T) {
+	// The whole field kills
+	killZone := types.MapObject{
+		Position:      types.Vector{X: -10, Y: -10},
+		CollisionArea: types.CollisionArea{X: types.FieldMaxX + 20, Y: types.FieldMaxY + 20},
+		Mask:          types.CL_ALL,
+		IsTrigger:     true,
+		Action:        types.TA_KILL,
+	}
+	ge := RunGameEngine(discardLog{}, []types.MapObject{killZone}, simulation.Config{Seed: 1})
+	serverEnd, clientEnd := net.Pipe()
+	client := transport.NewStreamConn(clientEnd)
+	defer client.Close()
+	go ge.HandleConnection(transport.NewStreamConn(serverEnd))
+
+	clientEnd.SetDeadline(time.Now().Add(5 * time.Second))
+	hello := types.ClientHello{ProtocolVersion: types.ProtocolVersion, PlayerName: "victim"}
+	err := client.WriteMessage(hello.ToMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ReadMessage()
+	if err != nil || msg.Type != types.MT_INITIALIZATION_DATA {
+		t.Fatalf("got %s %v, want initialization data", msg.Type.ToString(), err)
+	}
+	initData, err := types.InitializationDataFromBytes(bytes.NewReader(msg.Payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	died := false
+	for {
+		msg, err := client.ReadMessage()
+		if err != nil {
+			// Closed by the server after the last snapshot
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("connection ended with %v, want EOF", err)
+			}
+			break
+		}
+		if msg.Type != types.MT_EVENTS {
+			continue
+		}
+		events, err := types.GameEventsFromBytes(bytes.NewReader(msg.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if event.Type == types.ET_DEATH && event.PlayerID == initData.PlayerID {
+				died = true
+			}
+		}
+	}
+	if !died {
+		t.Fatal("the player didn't get their own death")
+	}
+}
@@ -0,0 +1,118 @@
+package simulation
+
+import (
+	"math"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// Side of a grid cell in field cells. Most objects and their moves in a
+// tick are smaller than this, so a move looks at one to four grid cells.
+const gridCellSize = 8
+
+type gridCell struct {
+	X, Y int
+}
+
+// gridBounds are the first and the last grid cell a box touches.
+type gridBounds struct {
+	Min, Max gridCell
+}
+
+func gridBoundsOf(box types.CollisionBox) gridBounds {
+	return gridBounds{
+		Min: gridCell{
+			X: int(math.Floor(box.BottomLeft.X / gridCellSize)),
+			Y: int(math.Floor(box.BottomLeft.Y / gridCellSize)),
+		},
+		Max: gridCell{
+			X: int(math.Floor(box.TopRight.X / gridCellSize)),
+			Y: int(math.Floor(box.TopRight.Y / gridCellSize)),
+		},
+	}
+}
+
+// spatialGrid is the broad phase of collision detection: a uniform grid
+// that finds objects near a box without looking at every object.
+type spatialGrid[K comparable] struct {
+	cells  map[gridCell][]K
+	bounds map[K]gridBounds
+}
+
+func newSpatialGrid[K comparable]() *spatialGrid[K] {
+	return &spatialGrid[K]{cells: map[gridCell][]K{}, bounds: map[K]gridBounds{}}
+}
+
+func (g *spatialGrid[K]) insert(key K, box types.CollisionBox) {
+	bounds := gridBoundsOf(box)
+	g.bounds[key] = bounds
+	for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y <= bounds.Max.Y; y++ {
+			cell := gridCell{x, y}
+			g.cells[cell] = append(g.cells[cell], key)
+		}
+	}
+}
+
+func (g *spatialGrid[K]) remove(key K) {
+	bounds, ok := g.bounds[key]
+	if !ok {
+		return
+	}
+	delete(g.bounds, key)
+	for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y <= bounds.Max.Y; y++ {
+			cell := gridCell{x, y}
+			keys := g.cells[cell]
+			for i, k := range keys {
+				if k == key {
+					keys[i] = keys[len(keys)-1]
+					keys = keys[:len(keys)-1]
+					break
+				}
+			}
+			if len(keys) == 0 {
+				delete(g.cells, cell)
+			} else {
+				g.cells[cell] = keys
+			}
+		}
+	}
+}
+
+// move updates the cells of an object, it is cheap when the object stays
+// within the same cells.
+func (g *spatialGrid[K]) move(key K, box types.CollisionBox) {
+	if bounds, ok := g.bounds[key]; ok && bounds == gridBoundsOf(box) {
+		return
+	}
+	g.remove(key)
+	g.insert(key, box)
+}
+
+func (g *spatialGrid[K]) reset() {
+	clear(g.cells)
+	clear(g.bounds)
+}
+
+// query calls fn once for every object sharing a grid cell with the box,
+// until fn returns false. The objects may not intersect the box, that is
+// up to the caller to check.
+func (g *spatialGrid[K]) query(box types.CollisionBox, fn func(K) bool) {
+	bounds := gridBoundsOf(box)
+	for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y <= bounds.Max.Y; y++ {
+			for _, key := range g.cells[gridCell{x, y}] {
+				// An object spanning several cells is reported only from
+				// the first cell it shares with the box
+				keyBounds := g.bounds[key]
+				if x != max(bounds.Min.X, keyBounds.Min.X) || y != max(bounds.Min.Y, keyBounds.Min.Y) {
+					continue
+				}
+				if !fn(key) {
+					return
+				}
+			}
+		}
+	}
+}
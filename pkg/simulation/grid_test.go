@@ -0,0 +1,113 @@
+package simulation
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+func box(x, y, w, h float64) types.CollisionBox {
+	return types.CollisionBox{
+		BottomLeft: types.Vector{X: x, Y: y},
+		TopRight:   types.Vector{X: x + w, Y: y + h},
+	}
+}
+
+// found returns the keys reported for the box, in the order of the calls.
+func found(g *spatialGrid[int], b types.CollisionBox) []int {
+	keys := []int{}
+	g.query(b, func(key int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestGridQuery(t *testing.T) {
+	g := newSpatialGrid[int]()
+	g.insert(1, box(1, 1, 1, 1))
+	// Spans 4x3 grid cells
+	g.insert(2, box(-3, 2, 30, 20))
+	g.insert(3, box(100, 100, 1, 1))
+	g.insert(4, box(-20, -20, 2, 2))
+
+	tests := []struct {
+		name  string
+		query types.CollisionBox
+		want  []int
+	}{
+		{"single cell", box(0, 0, 3, 3), []int{1, 2}},
+		{"whole spanning object", box(-10, -10, 50, 50), []int{1, 2}},
+		{"middle of spanning object", box(12, 12, 1, 1), []int{2}},
+		{"last cell of spanning object", box(25, 20, 1, 1), []int{2}},
+		{"negative cells", box(-19, -19, 0.5, 0.5), []int{4}},
+		{"far away", box(100, 100, 0.5, 0.5), []int{3}},
+		{"empty area", box(60, 60, 1, 1), []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := found(g, tt.query)
+			slices.Sort(got)
+			// Objects spanning several cells are reported once
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGridQueryStops(t *testing.T) {
+	g := newSpatialGrid[int]()
+	for i := range 10 {
+		g.insert(i, box(float64(i), 0, 1, 1))
+	}
+	calls := 0
+	g.query(box(0, 0, 10, 1), func(int) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("got %d calls after returning false, want 1", calls)
+	}
+}
+
+func TestGridRemove(t *testing.T) {
+	g := newSpatialGrid[int]()
+	g.insert(1, box(0, 0, 20, 20))
+	g.insert(2, box(1, 1, 1, 1))
+
+	g.remove(1)
+	if got := found(g, box(-5, -5, 40, 40)); !slices.Equal(got, []int{2}) {
+		t.Errorf("after remove got %v, want [2]", got)
+	}
+	g.remove(2)
+	if len(g.cells) != 0 || len(g.bounds) != 0 {
+		t.Errorf("empty grid keeps %d cells and %d bounds", len(g.cells), len(g.bounds))
+	}
+	// Removing an unknown key does nothing
+	g.remove(3)
+}
+
+func TestGridMove(t *testing.T) {
+	g := newSpatialGrid[int]()
+	g.insert(1, box(1, 1, 1, 1))
+
+	// Within the same cell
+	g.move(1, box(2, 2, 1, 1))
+	if got := found(g, box(0, 0, 1, 1)); !slices.Equal(got, []int{1}) {
+		t.Errorf("move within cell: got %v, want [1]", got)
+	}
+
+	// Across cells, spanning several
+	g.move(1, box(30, 30, 10, 1))
+	if got := found(g, box(0, 0, 1, 1)); len(got) != 0 {
+		t.Errorf("old cell still has %v", got)
+	}
+	if got := found(g, box(38, 30, 1, 1)); !slices.Equal(got, []int{1}) {
+		t.Errorf("new cells: got %v, want [1]", got)
+	}
+	if got := found(g, box(0, 0, 100, 100)); !slices.Equal(got, []int{1}) {
+		t.Errorf("whole grid: got %v, want [1]", got)
+	}
+}
@@ -0,0 +1,282 @@
+package simulation
+
+import (
+	"math"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// canHit tells whether the moving object collides with the player. Players
+// and projectiles have separate IDs, so only a player can be the player
+// itself. A player who died this tick is removed on the next one, until
+// then nothing hits them.
+func (s *Simulation) canHit(obj types.MovableObject, player *types.Player) bool {
+	if player.HP == 0 {
+		return false
+	}
+	switch obj := obj.(type) {
+	case *types.Player:
+		return obj.ID != player.ID
+	case *types.Projectile:
+		return obj.OwnerID != player.ID || s.state.TickNumber >= obj.SpawnTick+s.selfHitGraceTicks
+	}
+	return true
+}
+
+// sweep finds the first object the box runs into while moving, nil if the
+// way is free. Map objects not blocking the layer of the object are passed
+// through. Map objects win ties with players.
+func (s *Simulation) sweep(
+	obj types.MovableObject,
+	box types.CollisionBox,
+	movement types.Vector,
+) (types.CollidableObject, sweepHit) {
+	bounds := sweptBounds(box, movement)
+
+	var collidesWith types.CollidableObject
+	first := sweepHit{}
+	consider := func(other types.CollidableObject, otherBox types.CollisionBox) {
+		hit, ok := sweepAABB(box, movement, otherBox)
+		if ok && (collidesWith == nil || hit.time < first.time) {
+			collidesWith, first = other, hit
+		}
+	}
+
+	s.mapGrid.query(bounds, func(i int) bool {
+		mo := &s.state.MapObjects[i]
+		if mo.Blocks(obj.GetCollisionLayer()) {
+			consider(mo, mo.GetCollisionBox())
+		}
+		return true
+	})
+	s.playerGrid.query(bounds, func(id types.ObjectID) bool {
+		p, ok := s.state.Players[id]
+		if ok && s.canHit(obj, p) {
+			consider(p, p.GetCollisionBox())
+		}
+		return true
+	})
+	return collidesWith, first
+}
+
+// maxSlides limits how many times a move continues along a surface, a
+// move is stopped on each axis at most once.
+const maxSlides = 3
+
+// moveObject moves the object by its speed over dt seconds. It stops
+// exactly where it touches an obstacle and slides along it for the rest
+// of the move. Landed is true if the object fell onto something, i.e. it
+// stands on the ground.
+func (s *Simulation) moveObject(obj types.MovableObject, dt float64) (collidesWith types.CollidableObject, landed bool) {
+	speed := obj.GetSpeed()
+	movement := speed.Multiply(dt)
+	box := obj.GetCollisionArea().ToCollisionBox(obj.GetPosition())
+
+	for range maxSlides {
+		if movement == (types.Vector{}) {
+			break
+		}
+		other, hit := s.sweep(obj, box, movement)
+		if other == nil {
+			box = box.Add(movement)
+			break
+		}
+		if collidesWith == nil {
+			collidesWith = other
+		}
+
+		box = box.Add(movement.Multiply(hit.time))
+		movement = movement.Multiply(1 - hit.time)
+		if hit.blocksX {
+			speed.X = 0
+			movement.X = 0
+		}
+		if hit.blocksY {
+			landed = landed || speed.Y < 0
+			speed.Y = 0
+			movement.Y = 0
+		}
+	}
+	obj.SetSpeed(speed)
+	obj.SetPosition(box.BottomLeft)
+	return collidesWith, landed
+}
+
+// applyDrag slows the speed down by drag * speed^2 per second. It is the
+// exact solution over dt, so a long tick can't flip the direction.
+func applyDrag(speed float64, drag float64, dt float64) float64 {
+	return speed / (1 + drag*math.Abs(speed)*dt)
+}
+
+func (s *Simulation) calculateState(dt float64) {
+	playerIDs := s.playerIDs()
+	s.playerGrid.reset()
+	for _, id := range playerIDs {
+		player := s.state.Players[id]
+		s.playerGrid.insert(player.ID, player.GetCollisionBox())
+	}
+
+	for _, id := range playerIDs {
+		player := s.state.Players[id]
+		// "gravity"
+		player.Speed.Y -= s.physics.Gravity * dt
+
+		//fmt.Printf("%s\n", player.ToString())
+
+		from := player.GetCollisionBox()
+		_, landed := s.moveObject(player, dt)
+		s.playerGrid.move(player.ID, player.GetCollisionBox())
+		player.IsAirborn = !landed
+		if landed {
+			player.GroundedTick = s.state.TickNumber
+		}
+		s.updateTriggers(player, from)
+
+		if player.HP == 0 {
+			death := types.GameEvent{Type: types.ET_DEATH, PlayerID: player.ID}
+			// A player not hit recently died on their own, e.g. in a kill zone
+			if player.LastHitTick != 0 && s.state.TickNumber-player.LastHitTick <= s.killCreditTicks {
+				death.ShooterID = player.LastHitBy
+				death.ShooterName = s.playerName(player.LastHitBy)
+			}
+			s.addPlayerEvent(death)
+			s.RemovePlayer(player.ID)
+			continue
+		}
+
+		// "slowing", friction stops slow players on the ground only
+		newSpeed := types.Vector{
+			X: applyDrag(player.Speed.X, s.physics.DragX, dt),
+			Y: applyDrag(player.Speed.Y, s.physics.DragY, dt),
+		}
+		if math.Abs(player.Speed.X) < s.physics.FrictionBoundary && !player.IsAirborn {
+			newSpeed.X = 0
+		}
+
+		player.Speed = newSpeed
+	}
+
+	for _, id := range s.projectileIDs() {
+		proj := s.state.Projectiles[id]
+		//fmt.Printf("Projectile %s\n", proj.Position.ToString())
+		from := proj.GetCollisionBox()
+		collidesWith, _ := s.moveObject(proj, dt)
+		if collidesWith != nil {
+			//fmt.Printf("Collides with: %v\n", collidesWith)
+			collidesWith.OnCollision(proj)
+			if player, ok := collidesWith.(*types.Player); ok {
+				player.LastHitBy = proj.OwnerID
+				player.LastHitTick = s.state.TickNumber
+				s.addPlayerEvent(types.GameEvent{
+					Type:        types.ET_HIT,
+					PlayerID:    player.ID,
+					ObjectID:    proj.ID,
+					ShooterID:   proj.OwnerID,
+					ShooterName: s.playerName(proj.OwnerID),
+				})
+			}
+			s.removeProjectile(proj.ID)
+			continue
+		}
+		s.updateTriggers(proj, from)
+	}
+}
+
+// applyInputs takes inputs meant for the current tick or earlier ones,
+// inputs for future ticks wait for their tick. Then the held input of every
+// player is applied, whether it changed this tick or not.
+func (s *Simulation) applyInputs() {
+	pending := s.inputs[:0]
+	for _, in := range s.inputs {
+		if in.input.Tick > s.state.TickNumber {
+			pending = append(pending, in)
+			continue
+		}
+		s.updateInput(in)
+	}
+	clear(s.inputs[len(pending):])
+	s.inputs = pending
+
+	for _, id := range s.playerIDs() {
+		player := s.state.Players[id]
+		s.applyHeldInput(player)
+		s.tryJump(player)
+	}
+}
+
+// updateInput replaces the held input of the player. Shooting happens when
+// the action gets pressed, holding it doesn't fire every tick. Toggling it
+// within a tick fires once, however many inputs the client sends.
+func (s *Simulation) updateInput(in playerInput) {
+	player, ok := s.state.Players[in.playerID]
+	if !ok {
+		return
+	}
+	// Duplicated or reordered input, a newer one was already applied
+	if in.input.Sequence <= player.LastInput {
+		return
+	}
+	shootPressed := in.input.Actions.Has(types.IA_SHOOT) && !player.Input.Actions.Has(types.IA_SHOOT)
+	if in.input.Actions.Has(types.IA_UP) && !player.Input.Actions.Has(types.IA_UP) {
+		player.JumpPressedTick = s.state.TickNumber
+	}
+	player.Input = in.input
+	player.LastInput = in.input.Sequence
+	player.ViewDirection = in.input.Aim
+
+	if shootPressed && player.LastShotTick != s.state.TickNumber {
+		player.LastShotTick = s.state.TickNumber
+		aim := player.ViewDirection.AsVector()
+		// Spread goes across the aim, along it a shot up or down could be
+		// slower than its owner
+		spread := types.Vector{X: -aim.Y, Y: aim.X}.Multiply((s.rng.Float64() - 0.5) * s.physics.ProjectileSpread)
+		s.addProjectile(
+			player.ID,
+			player.Position.Add(aim),
+			aim.Multiply(s.physics.ProjectileSpeed).Add(spread),
+		)
+	}
+}
+
+// tryJump makes the player jump if UP was pressed lately and the player
+// stood on the ground lately. Holding UP doesn't jump again on landing.
+func (s *Simulation) tryJump(player *types.Player) {
+	tick := s.state.TickNumber
+	if player.JumpPressedTick == 0 || tick > player.JumpPressedTick+s.jumpBufferTicks {
+		return
+	}
+	if player.GroundedTick == 0 || tick > player.GroundedTick+s.coyoteTicks {
+		return
+	}
+	player.Speed.Y = s.physics.JumpSpeed
+	player.JumpPressedTick = 0
+	// No second jump within the coyote time
+	player.GroundedTick = 0
+}
+
+// applyHeldInput applies the held input of the player, once per tick.
+// Opposite directions held together cancel each other. DOWN pulls the
+// player down faster, UP is a jump, see tryJump.
+func (s *Simulation) applyHeldInput(player *types.Player) {
+	actions := player.Input.Actions
+	run := actions.Has(types.IA_RUN)
+	switch {
+	case actions.Has(types.IA_RIGHT) && !actions.Has(types.IA_LEFT):
+		player.Speed.X = s.accelerateX(player.Speed.X, run)
+	case actions.Has(types.IA_LEFT) && !actions.Has(types.IA_RIGHT):
+		player.Speed.X = -s.accelerateX(-player.Speed.X, run)
+	}
+	if actions.Has(types.IA_DOWN) && !actions.Has(types.IA_UP) {
+		player.Speed.Y = min(player.Speed.Y, -s.physics.JumpSpeed)
+	}
+}
+
+// accelerateX returns the horizontal speed while a direction is held,
+// speed is positive towards that direction. Faster movement, e.g. after a
+// hit, is not slowed down.
+func (s *Simulation) accelerateX(speed float64, run bool) float64 {
+	if run {
+		return max(speed, s.physics.RunSpeed)
+	}
+	return max(speed, s.physics.StepSpeed)
+}
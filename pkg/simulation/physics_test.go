@@ -0,0 +1,248 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+var floorMap = []types.MapObject{
+	{Position: types.Vector{X: -10, Y: -1}, CollisionArea: types.CollisionArea{X: 100, Y: 1}, Mask: types.CL_ALL},
+}
+
+// addPlayerAt adds a player standing still at the position.
+func addPlayerAt(s *Simulation, name string, position types.Vector) *types.Player {
+	player := s.state.Players[s.AddPlayer(name)]
+	player.Position = position
+	return player
+}
+
+func eventsOfType(events types.GameEvents, eventType types.EventType) types.GameEvents {
+	found := types.GameEvents{}
+	for _, event := range events {
+		if event.Type == eventType {
+			found = append(found, event)
+		}
+	}
+	return found
+}
+
+func TestTwoHitsInOneTick(t *testing.T) {
+	s := New(floorMap, Config{Seed: 1})
+	first := addPlayerAt(s, "first", types.Vector{X: 40, Y: 0})
+	second := addPlayerAt(s, "second", types.Vector{X: 50, Y: 0})
+	victim := addPlayerAt(s, "victim", types.Vector{X: 10, Y: 0})
+	victim.HP = 1
+	// Both reach the victim in the next tick
+	s.addProjectile(first.ID, types.Vector{X: 8.5, Y: 0}, types.Vector{X: 50})
+	s.addProjectile(second.ID, types.Vector{X: 8.5, Y: 0}, types.Vector{X: 50})
+	s.TakeEvents()
+
+	s.Step()
+	if victim.HP != 0 {
+		t.Fatalf("victim HP %d, want 0", victim.HP)
+	}
+	hits := eventsOfType(s.TakeEvents(), types.ET_HIT)
+	if len(hits) != 1 || hits[0].ShooterID != first.ID {
+		t.Fatalf("hits %+v, want one by the first shooter", hits)
+	}
+	if len(s.state.Projectiles) != 1 {
+		t.Errorf("%d projectiles left, the second one must fly on", len(s.state.Projectiles))
+	}
+
+	s.Step()
+	if _, ok := s.state.Players[victim.ID]; ok {
+		t.Fatal("victim is still in the game")
+	}
+	deaths := eventsOfType(s.TakeEvents(), types.ET_DEATH)
+	if len(deaths) != 1 || deaths[0].ShooterID != first.ID {
+		t.Fatalf("deaths %+v, want one credited to the first shooter", deaths)
+	}
+}
+
+func TestSelfHitGracePeriod(t *testing.T) {
+	tests := []struct {
+		name string
+		// Age of the projectile when it reaches the owner
+		age types.GameTick
+		hit bool
+	}{
+		{"just fired", 1, false},
+		{"end of the grace period", 12, false},
+		{"after the grace period", 13, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(floorMap, Config{Seed: 1})
+			if s.selfHitGraceTicks != 13 {
+				t.Fatalf("grace period is %d ticks, the cases expect 13", s.selfHitGraceTicks)
+			}
+			for range 20 {
+				s.Step()
+			}
+			owner := addPlayerAt(s, "", types.Vector{X: 10, Y: 0})
+			s.addProjectile(owner.ID, types.Vector{X: 8.5, Y: 0}, types.Vector{X: 50})
+			s.state.Projectiles[0].SpawnTick = s.Tick() + 1 - tt.age
+
+			s.Step()
+			if hit := owner.HP < 5; hit != tt.hit {
+				t.Errorf("hit %v, want %v", hit, tt.hit)
+			}
+		})
+	}
+}
+
+func TestCanHit(t *testing.T) {
+	s := New(nil, Config{Seed: 1})
+	player := &types.Player{ID: 1, HP: 5}
+	other := &types.Player{ID: 2, HP: 5}
+	otherShot := &types.Projectile{OwnerID: 2}
+
+	if s.canHit(player, player) {
+		t.Error("a player hits themselves")
+	}
+	if !s.canHit(other, player) || !s.canHit(otherShot, player) {
+		t.Error("other player or their shot doesn't hit")
+	}
+	player.HP = 0
+	if s.canHit(other, player) || s.canHit(otherShot, player) {
+		t.Error("a dead player is hit")
+	}
+}
+
+// runActions steps the simulation, from each tick in script on the player
+// holds the actions given for it. It returns the ticks on which the player
+// started a jump.
+func runActions(s *Simulation, player *types.Player, ticks int, script map[types.GameTick]types.InputAction) []types.GameTick {
+	jumps := []types.GameTick{}
+	sequence := uint32(0)
+	for range ticks {
+		tick := s.Tick() + 1
+		if actions, ok := script[tick]; ok {
+			sequence++
+			s.ApplyInput(player.ID, types.InputCommand{Sequence: sequence, Tick: tick, Actions: actions, Aim: types.D_RIGHT})
+		}
+		rising := player.Speed.Y > 0
+		s.Step()
+		if !rising && player.Speed.Y > 0 {
+			jumps = append(jumps, tick)
+		}
+	}
+	return jumps
+}
+
+// fallingPlayer drops a player onto the floor, landing tells the tick they
+// touch it.
+func fallingPlayer(t *testing.T) (s *Simulation, player *types.Player, landing types.GameTick) {
+	t.Helper()
+	newFall := func() (*Simulation, *types.Player) {
+		s := New(floorMap, Config{Seed: 1})
+		return s, addPlayerAt(s, "", types.Vector{X: 0, Y: 3})
+	}
+	s, player = newFall()
+	for player.GroundedTick == 0 {
+		if s.Tick() > 100 {
+			t.Fatal("player never landed")
+		}
+		s.Step()
+	}
+	landing = player.GroundedTick
+	s, player = newFall()
+	return s, player, landing
+}
+
+// ledgePlayer runs a player off the end of the floor, ledge tells the last
+// tick they stand on it.
+func ledgePlayer(t *testing.T) (s *Simulation, player *types.Player, ledge types.GameTick) {
+	t.Helper()
+	ledgeMap := []types.MapObject{
+		{Position: types.Vector{X: -10, Y: -1}, CollisionArea: types.CollisionArea{X: 20, Y: 1}, Mask: types.CL_ALL},
+	}
+	newRun := func() (*Simulation, *types.Player) {
+		s := New(ledgeMap, Config{Seed: 1})
+		return s, addPlayerAt(s, "", types.Vector{X: 5, Y: 0})
+	}
+	s, player = newRun()
+	runActions(s, player, 1, map[types.GameTick]types.InputAction{1: types.IA_RIGHT})
+	for !player.IsAirborn {
+		if s.Tick() > 100 {
+			t.Fatal("player never left the floor")
+		}
+		s.Step()
+	}
+	ledge = player.GroundedTick
+	s, player = newRun()
+	return s, player, ledge
+}
+
+func TestJumpBuffer(t *testing.T) {
+	s, _, _ := fallingPlayer(t)
+	if s.jumpBufferTicks != 3 {
+		t.Fatalf("jump buffer is %d ticks, the cases expect 3", s.jumpBufferTicks)
+	}
+	tests := []struct {
+		name string
+		// Ticks UP is pressed before landing
+		early types.GameTick
+		jump  bool
+	}{
+		{"pressed on landing", 0, true},
+		{"pressed shortly before", 2, true},
+		{"pressed too early", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, player, landing := fallingPlayer(t)
+			press := landing - tt.early
+			// A tap, released right away
+			jumps := runActions(s, player, 30, map[types.GameTick]types.InputAction{
+				press:     types.IA_UP,
+				press + 1: 0,
+			})
+			if jumped := len(jumps) > 0; jumped != tt.jump {
+				t.Errorf("landed on tick %d, UP on %d: jumps %v, want jump %v", landing, press, jumps, tt.jump)
+			}
+		})
+	}
+}
+
+func TestCoyoteTime(t *testing.T) {
+	s, _, _ := ledgePlayer(t)
+	if s.coyoteTicks != 3 {
+		t.Fatalf("coyote time is %d ticks, the cases expect 3", s.coyoteTicks)
+	}
+	tests := []struct {
+		name string
+		// Ticks UP is pressed after the last one on the floor
+		late types.GameTick
+		jump bool
+	}{
+		{"pressed on the floor", 0, true},
+		{"pressed shortly after", 3, true},
+		{"pressed too late", 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, player, ledge := ledgePlayer(t)
+			press := ledge + tt.late
+			jumps := runActions(s, player, 30, map[types.GameTick]types.InputAction{
+				1:         types.IA_RIGHT,
+				press:     types.IA_RIGHT | types.IA_UP,
+				press + 1: types.IA_RIGHT,
+			})
+			if jumped := len(jumps) > 0; jumped != tt.jump {
+				t.Errorf("left the floor after tick %d, UP on %d: jumps %v, want jump %v", ledge, press, jumps, tt.jump)
+			}
+		})
+	}
+}
+
+func TestHeldJumpDoesNotRepeat(t *testing.T) {
+	s := New(floorMap, Config{Seed: 1})
+	player := addPlayerAt(s, "", types.Vector{X: 0, Y: 0})
+	// UP stays held long after the landing
+	jumps := runActions(s, player, 200, map[types.GameTick]types.InputAction{1: types.IA_UP})
+	if len(jumps) != 1 {
+		t.Fatalf("jumps %v, want one", jumps)
+	}
+}
@@ -0,0 +1,103 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Physics is the tuning of movement, it can be changed between steps.
+// Physics doesn't depend on the tick rate: speeds are in cells per second,
+// accelerations in cells per second squared. Drag is per cell of speed.
+type Physics struct {
+	DragX float64 `json:"drag_x"`
+	DragY float64 `json:"drag_y"`
+	// Players on the ground slower than this stop
+	FrictionBoundary float64 `json:"friction_boundary"`
+	RunSpeed         float64 `json:"run_speed"`
+	StepSpeed        float64 `json:"step_speed"`
+	// Speed of a jump up, DOWN pulls the player down as fast
+	JumpSpeed        float64 `json:"jump_speed"`
+	Gravity          float64 `json:"gravity"`
+	ProjectileSpeed  float64 `json:"projectile_speed"`
+	ProjectileSpread float64 `json:"projectile_spread"`
+}
+
+func DefaultPhysics() Physics {
+	return Physics{
+		DragX:            0.1,
+		DragY:            0.1,
+		FrictionBoundary: 17.5,
+		RunSpeed:         42.5,
+		StepSpeed:        17.5,
+		JumpSpeed:        50,
+		Gravity:          125,
+		ProjectileSpeed:  50,
+		ProjectileSpread: 25,
+	}
+}
+
+// LoadPhysics reads a physics profile, values missing in the file are the
+// defaults.
+func LoadPhysics(path string) (Physics, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Physics{}, err
+	}
+	physics := DefaultPhysics()
+	err = json.Unmarshal(b, &physics)
+	if err != nil {
+		return Physics{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	err = physics.Validate()
+	if err != nil {
+		return Physics{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return physics, nil
+}
+
+// Speeds and accelerations are capped far above anything playable on the
+// field, so that speeds stay well within the fixed point wire encoding
+// (about 2^21 cells per second). Drag only slows down and has no cap.
+const (
+	maxPhysicsSpeed   = 10_000
+	maxPhysicsGravity = 100_000
+)
+
+// Validate rejects negative values, they would turn the movement around
+// or blow up drag, and values too large for the wire.
+func (p Physics) Validate() error {
+	values := []struct {
+		name  string
+		value float64
+		max   float64
+	}{
+		{"drag_x", p.DragX, math.Inf(1)},
+		{"drag_y", p.DragY, math.Inf(1)},
+		{"friction_boundary", p.FrictionBoundary, maxPhysicsSpeed},
+		{"run_speed", p.RunSpeed, maxPhysicsSpeed},
+		{"step_speed", p.StepSpeed, maxPhysicsSpeed},
+		{"jump_speed", p.JumpSpeed, maxPhysicsSpeed},
+		{"gravity", p.Gravity, maxPhysicsGravity},
+		{"projectile_speed", p.ProjectileSpeed, maxPhysicsSpeed},
+		{"projectile_spread", p.ProjectileSpread, maxPhysicsSpeed},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("%s is negative: %v", v.name, v.value)
+		}
+		if v.value > v.max {
+			return fmt.Errorf("%s is above %v: %v", v.name, v.max, v.value)
+		}
+	}
+	return nil
+}
+
+func (p Physics) ToString() string {
+	return fmt.Sprintf(
+		"gravity %v, jump %v, run %v, step %v, friction below %v, drag %v/%v, projectile %v±%v",
+		p.Gravity, p.JumpSpeed, p.RunSpeed, p.StepSpeed, p.FrictionBoundary,
+		p.DragX, p.DragY, p.ProjectileSpeed, p.ProjectileSpread/2,
+	)
+}
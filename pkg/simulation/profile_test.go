@@ -0,0 +1,56 @@
+package simulation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPhysicsValid(t *testing.T) {
+	err := DefaultPhysics().Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPhysics(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		// Part of the error, empty if the profile loads
+		err string
+	}{
+		{"valid", `{"gravity": 80, "jump_speed": 40}`, ""},
+		{"negative", `{"drag_x": -0.5}`, "drag_x is negative"},
+		{"out of range", `{"jump_speed": 3000000}`, "jump_speed is above"},
+		{"gravity out of range", `{"gravity": 1e9}`, "gravity is above"},
+		{"not JSON", `gravity: 80`, "parsing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "physics.json")
+			err := os.WriteFile(path, []byte(tt.profile), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			physics, err := LoadPhysics(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := DefaultPhysics()
+			want.Gravity = 80
+			want.JumpSpeed = 40
+			if physics != want {
+				t.Errorf("got %+v, want %+v", physics, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Package simulation is the game world without networking: players,
+// projectiles and physics stepped one tick at a time. It is not safe for
+// concurrent use, the caller keeps it behind a lock if needed.
+package simulation
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+const (
+	DefaultTickRate   = 25
+	maxInputLeadTicks = 10
+	// A tick must stay long enough for timers and durations in ticks
+	MaxTickRate = 1000
+	// Snapshots can't carry more, shots over the limit are not fired
+	maxProjectiles = types.MaxEntityCount
+	// UP still jumps for a while after walking off a ledge (coyote time),
+	// and UP pressed shortly before landing jumps on landing (jump buffer)
+	coyoteTime     = 100 * time.Millisecond
+	jumpBufferTime = 100 * time.Millisecond
+	// A projectile doesn't hit its owner for a while after the shot. The
+	// owner moves first in a tick and may step into a shot that is just
+	// slightly faster, by the end of the period the shot is far enough.
+	selfHitGracePeriod = 500 * time.Millisecond
+	// A player dying on their own, e.g. in a kill zone, is credited to the
+	// last shooter who hit them within this period
+	killCreditPeriod = 5 * time.Second
+)
+
+// TriggerHandler is called when a player or a projectile enters or leaves a
+// trigger, trigger is its index in the map objects. It runs inside Step,
+// before the action of the trigger, and may change obj but must not call
+// the Simulation.
+type TriggerHandler func(eventType types.EventType, trigger int, obj types.MovableObject)
+
+type Config struct {
+	// Simulation ticks per second, DefaultTickRate if not positive, at most
+	// MaxTickRate
+	TickRate int
+	// Seed of all randomness in the match, a random one if zero. The same
+	// seed and inputs give the same game.
+	Seed int64
+	// DefaultPhysics if zero
+	Physics Physics
+	// Optional
+	OnTrigger TriggerHandler
+}
+
+type playerInput struct {
+	playerID types.ObjectID
+	input    types.InputCommand
+}
+
+type Simulation struct {
+	newPlayerID     types.ObjectID
+	newProjectileID types.ObjectID
+	// Inputs waiting for their tick
+	inputs []playerInput
+
+	state types.GameState
+	// Events since the last TakeEvents
+	events types.GameEvents
+
+	// Broad phase of collision detection. Map objects are indexes into
+	// state.MapObjects and never move, players are re-added every tick.
+	mapGrid    *spatialGrid[int]
+	playerGrid *spatialGrid[types.ObjectID]
+	// Triggers each object is in, as indexes into state.MapObjects
+	triggerContacts map[objectKey][]int
+	triggerHandler  TriggerHandler
+
+	tickRate int
+	// Duration of a tick in seconds
+	dt                float64
+	selfHitGraceTicks types.GameTick
+	coyoteTicks       types.GameTick
+	jumpBufferTicks   types.GameTick
+	killCreditTicks   types.GameTick
+
+	physics Physics
+
+	seed int64
+	rng  *rand.Rand
+}
+
+func New(mapObjects []types.MapObject, config Config) *Simulation {
+	if config.TickRate <= 0 {
+		config.TickRate = DefaultTickRate
+	}
+	config.TickRate = min(config.TickRate, MaxTickRate)
+	if config.Physics == (Physics{}) {
+		config.Physics = DefaultPhysics()
+	}
+	for config.Seed == 0 {
+		config.Seed = rand.Int63()
+	}
+	s := &Simulation{
+		state: types.GameState{
+			Players:     types.PlayerMap{},
+			Projectiles: types.ProjectileMap{},
+			MapObjects:  mapObjects,
+		},
+		mapGrid:         newSpatialGrid[int](),
+		playerGrid:      newSpatialGrid[types.ObjectID](),
+		triggerContacts: map[objectKey][]int{},
+		triggerHandler:  config.OnTrigger,
+		tickRate:        config.TickRate,
+		dt:              1 / float64(config.TickRate),
+		physics:         config.Physics,
+		seed:            config.Seed,
+		rng:             rand.New(rand.NewSource(config.Seed)),
+	}
+	s.selfHitGraceTicks = s.durationToTicks(selfHitGracePeriod)
+	s.coyoteTicks = s.durationToTicks(coyoteTime)
+	s.jumpBufferTicks = s.durationToTicks(jumpBufferTime)
+	s.killCreditTicks = s.durationToTicks(killCreditPeriod)
+	for i, mo := range mapObjects {
+		s.mapGrid.insert(i, mo.GetCollisionBox())
+	}
+	return s
+}
+
+func (s *Simulation) TickRate() int {
+	return s.tickRate
+}
+
+// TickDuration is the game time simulated by one Step.
+func (s *Simulation) TickDuration() time.Duration {
+	return time.Second / time.Duration(s.tickRate)
+}
+
+// Seed is the seed of the match, also when it was picked at random.
+func (s *Simulation) Seed() int64 {
+	return s.seed
+}
+
+// durationToTicks rounds up, so that even a high tick rate gives the
+// whole duration.
+func (s *Simulation) durationToTicks(d time.Duration) types.GameTick {
+	return types.GameTick(math.Ceil(d.Seconds() * float64(s.tickRate)))
+}
+
+func (s *Simulation) Physics() Physics {
+	return s.physics
+}
+
+// SetPhysics replaces the physics from the next Step on.
+func (s *Simulation) SetPhysics(physics Physics) {
+	s.physics = physics
+}
+
+func (s *Simulation) Tick() types.GameTick {
+	return s.state.TickNumber
+}
+
+func (s *Simulation) MapObjects() []types.MapObject {
+	return s.state.MapObjects
+}
+
+func (s *Simulation) PlayerCount() int {
+	return len(s.state.Players)
+}
+
+// AddPlayer spawns a new player, an empty name is replaced by a generated
+// one.
+func (s *Simulation) AddPlayer(name string) types.ObjectID {
+	newID := s.newPlayerID
+	s.newPlayerID++
+	if name == "" {
+		name = fmt.Sprintf("player-%d", newID)
+	}
+	area := types.CollisionArea{X: 0.9, Y: 0.9}
+	s.state.Players[newID] = &types.Player{
+		ID:            newID,
+		Name:          name,
+		ViewDirection: types.D_RIGHT,
+		Position:      s.spawnPosition(area),
+		CollisionArea: area,
+		HP:            5,
+	}
+	s.addEvent(types.GameEvent{Type: types.ET_JOIN, PlayerID: newID, PlayerName: name})
+	return newID
+}
+
+// spawnPosition returns the first free spot along the diagonal from the
+// bottom left corner, then row by row. A player spawned on top of another
+// one or inside a map object would be stuck in it.
+func (s *Simulation) spawnPosition(area types.CollisionArea) types.Vector {
+	free := func(position types.Vector) bool {
+		box := area.ToCollisionBox(position)
+		for _, player := range s.state.Players {
+			if player.GetCollisionBox().IntersectsWith(box) {
+				return false
+			}
+		}
+		found := false
+		s.mapGrid.query(box, func(i int) bool {
+			found = s.state.MapObjects[i].GetCollisionBox().IntersectsWith(box)
+			return !found
+		})
+		return !found
+	}
+
+	for i := range min(types.FieldMaxX, types.FieldMaxY) {
+		position := types.Vector{X: float64(i), Y: float64(i)}
+		if free(position) {
+			return position
+		}
+	}
+	for y := range types.FieldMaxY {
+		for x := range types.FieldMaxX {
+			position := types.Vector{X: float64(x), Y: float64(y)}
+			if free(position) {
+				return position
+			}
+		}
+	}
+	return types.Vector{}
+}
+
+// RemovePlayer takes the player out of the game, it does nothing if the
+// player is already gone.
+func (s *Simulation) RemovePlayer(playerID types.ObjectID) {
+	player, ok := s.state.Players[playerID]
+	if !ok {
+		return
+	}
+	s.addEvent(types.GameEvent{Type: types.ET_LEAVE, PlayerID: playerID, PlayerName: player.Name})
+	delete(s.state.Players, playerID)
+	delete(s.triggerContacts, keyOf(player))
+}
+
+// ApplyInput queues the input for the tick it is meant for. Inputs for the
+// current tick or earlier ones are applied by the next Step.
+func (s *Simulation) ApplyInput(playerID types.ObjectID, input types.InputCommand) {
+	// Client can't hold the server back for long with inputs far in future
+	input.Tick = min(input.Tick, s.state.TickNumber+maxInputLeadTicks)
+	s.inputs = append(s.inputs, playerInput{playerID: playerID, input: input})
+}
+
+// Chat adds a chat message of the player to the events.
+func (s *Simulation) Chat(playerID types.ObjectID, text string) {
+	s.addPlayerEvent(types.GameEvent{Type: types.ET_CHAT, PlayerID: playerID, Text: text})
+}
+
+// Step advances the simulation by one tick.
+func (s *Simulation) Step() {
+	s.state.TickNumber++
+	s.applyInputs()
+	s.calculateState(s.dt)
+}
+
+// Snapshot returns a copy of the current state, it is not changed by
+// further steps.
+func (s *Simulation) Snapshot() types.GameState {
+	return s.state.Clone()
+}
+
+// TakeEvents returns events that happened since the previous call.
+func (s *Simulation) TakeEvents() types.GameEvents {
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// playerIDs returns the players in a fixed order, the order of map
+// iteration would make every run different.
+func (s *Simulation) playerIDs() []types.ObjectID {
+	return slices.Sorted(maps.Keys(s.state.Players))
+}
+
+func (s *Simulation) projectileIDs() []types.ObjectID {
+	return slices.Sorted(maps.Keys(s.state.Projectiles))
+}
+
+func (s *Simulation) addEvent(event types.GameEvent) {
+	event.Tick = s.state.TickNumber
+	s.events = append(s.events, event)
+}
+
+// addPlayerEvent fills in the player name, the player must still be in
+// the game.
+func (s *Simulation) addPlayerEvent(event types.GameEvent) {
+	event.PlayerName = s.playerName(event.PlayerID)
+	s.addEvent(event)
+}
+
+// playerName is empty for players that are not in the game.
+func (s *Simulation) playerName(playerID types.ObjectID) string {
+	if player, ok := s.state.Players[playerID]; ok {
+		return player.Name
+	}
+	return ""
+}
+
+func (s *Simulation) addProjectile(ownerID types.ObjectID, position types.Vector, speed types.Vector) {
+	if len(s.state.Projectiles) >= maxProjectiles {
+		return
+	}
+	newID := s.newProjectileID
+	s.newProjectileID++
+	s.state.Projectiles[newID] = &types.Projectile{
+		ID:            newID,
+		Rune:          '•',
+		Position:      position,
+		Speed:         speed,
+		CollisionArea: types.CollisionArea{X: 1, Y: 1},
+		OwnerID:       ownerID,
+		SpawnTick:     s.state.TickNumber,
+	}
+}
+
+func (s *Simulation) removeProjectile(projectileID types.ObjectID) {
+	delete(s.state.Projectiles, projectileID)
+	delete(s.triggerContacts, objectKey{layer: types.CL_PROJECTILE, id: projectileID})
+}
@@ -0,0 +1,218 @@
+package simulation
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+func TestProjectileLimit(t *testing.T) {
+	s := New(nil, Config{Seed: 1})
+	for range maxProjectiles + 10 {
+		s.addProjectile(0, types.Vector{}, types.Vector{X: 1})
+	}
+	if len(s.state.Projectiles) != maxProjectiles {
+		t.Fatalf("got %d projectiles, want %d", len(s.state.Projectiles), maxProjectiles)
+	}
+	// Must not panic
+	s.Snapshot().ToBytes()
+}
+
+func TestOneShotPerTick(t *testing.T) {
+	s := New(nil, Config{Seed: 1})
+	id := s.AddPlayer("")
+	sequence := uint32(0)
+	// A client toggling SHOOT in many inputs for the same tick
+	toggleShoot := func() {
+		for i := range 100 {
+			sequence++
+			actions := types.InputAction(0)
+			if i%2 == 0 {
+				actions = types.IA_SHOOT
+			}
+			s.ApplyInput(id, types.InputCommand{Sequence: sequence, Tick: s.Tick() + 1, Actions: actions, Aim: types.D_RIGHT})
+		}
+	}
+
+	for tick := 1; tick <= 3; tick++ {
+		toggleShoot()
+		s.Step()
+		if len(s.state.Projectiles) != tick {
+			t.Fatalf("tick %d: got %d projectiles, want %d", tick, len(s.state.Projectiles), tick)
+		}
+	}
+}
+
+func TestTickRateBounds(t *testing.T) {
+	for _, c := range []struct{ rate, want int }{
+		{0, DefaultTickRate},
+		{-5, DefaultTickRate},
+		{60, 60},
+		{MaxTickRate, MaxTickRate},
+		{2_000_000_000, MaxTickRate},
+	} {
+		s := New(nil, Config{TickRate: c.rate, Seed: 1})
+		if s.TickRate() != c.want {
+			t.Errorf("tick rate %d: got %d, want %d", c.rate, s.TickRate(), c.want)
+		}
+		if s.TickDuration() <= 0 {
+			t.Errorf("tick rate %d: tick duration %v", c.rate, s.TickDuration())
+		}
+	}
+}
+
+// playScripted runs a match on the real map with inputs generated from
+// script: players join, run, jump, shoot and leave. It returns the
+// snapshot and the events of every tick.
+func playScripted(t *testing.T, seed int64, script int64, ticks int) (snapshots [][]byte, events [][]byte) {
+	t.Helper()
+	mapObjects, err := types.LoadMapObjects("../../map.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(mapObjects, Config{Seed: seed})
+	rng := rand.New(rand.NewSource(script))
+	for range 4 {
+		s.AddPlayer("")
+	}
+	sequence := uint32(0)
+	for range ticks {
+		switch rng.Intn(40) {
+		case 0:
+			s.AddPlayer("")
+		case 1:
+			if ids := s.playerIDs(); len(ids) > 0 {
+				s.RemovePlayer(ids[rng.Intn(len(ids))])
+			}
+		}
+		for _, id := range s.playerIDs() {
+			sequence++
+			s.ApplyInput(id, types.InputCommand{
+				Sequence: sequence,
+				Tick:     s.Tick() + 1,
+				Actions:  types.InputAction(rng.Intn(int(types.IA_SHOOT) << 1)),
+				Aim:      types.Direction(rng.Intn(4)),
+			})
+		}
+		s.Step()
+		snapshots = append(snapshots, s.Snapshot().ToBytes())
+		events = append(events, s.TakeEvents().ToBytes())
+	}
+	return snapshots, events
+}
+
+func TestSameSeedSameGame(t *testing.T) {
+	const ticks = 1000
+	snapshots, events := playScripted(t, 7, 1, ticks)
+	replayed, replayedEvents := playScripted(t, 7, 1, ticks)
+	for tick := range ticks {
+		if !bytes.Equal(snapshots[tick], replayed[tick]) {
+			t.Fatalf("tick %d: snapshots differ", tick+1)
+		}
+		if !bytes.Equal(events[tick], replayedEvents[tick]) {
+			t.Fatalf("tick %d: events differ", tick+1)
+		}
+	}
+
+	// The seed matters, projectiles spread differently
+	other, _ := playScripted(t, 8, 1, ticks)
+	if slices.EqualFunc(snapshots, other, bytes.Equal) {
+		t.Errorf("another seed gave the same game")
+	}
+}
+
+// BenchmarkStep measures a tick with 500 players running, jumping and
+// shooting on the real map and 500 projectiles in the air.
+func BenchmarkStep(b *testing.B) {
+	const players = 500
+	const projectiles = 500
+
+	mapObjects, err := types.LoadMapObjects("../../map.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := New(mapObjects, Config{Seed: 1})
+	rng := rand.New(rand.NewSource(1))
+	ids := []types.ObjectID{}
+	for range players {
+		id := s.AddPlayer("")
+		player := s.state.Players[id]
+		player.Position = types.Vector{X: rng.Float64() * 200, Y: rng.Float64() * 100}
+		// Nobody dies during the benchmark
+		player.HP = 1 << 30
+		ids = append(ids, id)
+	}
+	shoot := func() {
+		for len(s.state.Projectiles) < projectiles {
+			position := types.Vector{X: rng.Float64() * 200, Y: rng.Float64() * 100}
+			s.addProjectile(ids[rng.Intn(players)], position, types.Vector{X: 50, Y: rng.Float64()*25 - 12.5})
+		}
+	}
+	shoot()
+
+	sequence := uint32(0)
+	for b.Loop() {
+		for _, id := range ids[:players/10] {
+			sequence++
+			s.ApplyInput(id, types.InputCommand{
+				Sequence: sequence,
+				Tick:     s.Tick() + 1,
+				Actions:  types.InputAction(rng.Intn(int(types.IA_SHOOT) << 1)),
+				Aim:      types.D_RIGHT,
+			})
+		}
+		s.Step()
+		s.TakeEvents()
+		shoot()
+	}
+}
+
+func TestSpawnOnFreeSpot(t *testing.T) {
+	mapObjects, err := types.LoadMapObjects("../../map.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(mapObjects, Config{Seed: 1})
+	first := s.AddPlayer("")
+	s.AddPlayer("")
+	s.RemovePlayer(first)
+	for range 30 {
+		s.AddPlayer("")
+	}
+
+	players := s.state.Players
+	for _, id := range s.playerIDs() {
+		box := players[id].GetCollisionBox()
+		for _, other := range s.playerIDs() {
+			if other != id && box.IntersectsWith(players[other].GetCollisionBox()) {
+				t.Errorf("player %d spawned on player %d", id, other)
+			}
+		}
+		for i, mo := range mapObjects {
+			if box.IntersectsWith(mo.GetCollisionBox()) {
+				t.Errorf("player %d spawned in map object %d", id, i)
+			}
+		}
+	}
+}
+
+func TestOverlappingPlayersGetApart(t *testing.T) {
+	s := New(floorMap, Config{Seed: 1})
+	standing := addPlayerAt(s, "", types.Vector{X: 1, Y: 1})
+	walking := addPlayerAt(s, "", types.Vector{X: 1, Y: 1})
+	s.ApplyInput(walking.ID, types.InputCommand{Sequence: 1, Tick: 1, Actions: types.IA_RIGHT, Aim: types.D_RIGHT})
+	for range s.TickRate() {
+		s.Step()
+	}
+
+	if math.Abs(standing.Position.Y) > 1e-6 || math.Abs(walking.Position.Y) > 1e-6 {
+		t.Errorf("players at %s and %s didn't fall to the floor", standing.Position.ToString(), walking.Position.ToString())
+	}
+	if walking.Position.X < 2 {
+		t.Errorf("walking player stuck at %s", walking.Position.ToString())
+	}
+}
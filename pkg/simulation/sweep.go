@@ -0,0 +1,110 @@
+package simulation
+
+import (
+	"math"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// Boxes may overlap by this much and still count as touching. A move stops
+// half of it inside the obstacle, so floating point noise can't push an
+// object resting on a surface into an overlap and get it stuck.
+const contactEpsilon = 1e-9
+
+// sweepHit describes where a moving box first touches another one.
+type sweepHit struct {
+	// Fraction of the movement done before the contact, 0 to 1
+	time float64
+	// Axes the movement is stopped on, both for a corner or for boxes that
+	// already overlap
+	blocksX, blocksY bool
+}
+
+// overlapsAxis tells whether [lo, hi] overlaps [otherLo, otherHi] by more
+// than contactEpsilon.
+func overlapsAxis(lo, hi, otherLo, otherHi float64) bool {
+	return hi > otherLo+contactEpsilon && lo < otherHi-contactEpsilon
+}
+
+// axisEntry returns when the moving interval [lo, hi] reaches
+// [otherLo, otherHi] and when it leaves it while moving by delta, as
+// fractions of delta. It reaches the other interval half of contactEpsilon
+// inside and leaves it a whole contactEpsilon before its end. Without
+// movement the overlap is either always or never.
+func axisEntry(lo, hi, otherLo, otherHi, delta float64) (entry float64, exit float64) {
+	switch {
+	case delta > 0:
+		return (otherLo + contactEpsilon/2 - hi) / delta, (otherHi - contactEpsilon - lo) / delta
+	case delta < 0:
+		return (otherHi - contactEpsilon/2 - lo) / delta, (otherLo + contactEpsilon - hi) / delta
+	case overlapsAxis(lo, hi, otherLo, otherHi):
+		return math.Inf(-1), math.Inf(1)
+	}
+	return math.Inf(1), math.Inf(-1)
+}
+
+// deepensAxis tells whether moving [lo, hi] by delta makes its overlap with
+// [otherLo, otherHi] larger, i.e. the interval only sticks out on the side
+// it moves away from.
+func deepensAxis(lo, hi, otherLo, otherHi, delta float64) bool {
+	switch {
+	case delta > 0:
+		return lo < otherLo && hi < otherHi
+	case delta < 0:
+		return lo > otherLo && hi > otherHi
+	}
+	return false
+}
+
+// sweepAABB finds the first contact of the box moving by movement with the
+// other box, ok is false if they don't touch during the movement. Boxes
+// that only touch at the start block movement into each other, but not
+// sliding along each other. Boxes that already overlap block only movement
+// deeper into each other, so they can still get apart.
+func sweepAABB(box types.CollisionBox, movement types.Vector, other types.CollisionBox) (hit sweepHit, ok bool) {
+	if overlapsAxis(box.BottomLeft.X, box.TopRight.X, other.BottomLeft.X, other.TopRight.X) &&
+		overlapsAxis(box.BottomLeft.Y, box.TopRight.Y, other.BottomLeft.Y, other.TopRight.Y) {
+		hit = sweepHit{
+			blocksX: deepensAxis(box.BottomLeft.X, box.TopRight.X, other.BottomLeft.X, other.TopRight.X, movement.X),
+			blocksY: deepensAxis(box.BottomLeft.Y, box.TopRight.Y, other.BottomLeft.Y, other.TopRight.Y, movement.Y),
+		}
+		return hit, hit.blocksX || hit.blocksY
+	}
+
+	entryX, exitX := axisEntry(box.BottomLeft.X, box.TopRight.X, other.BottomLeft.X, other.TopRight.X, movement.X)
+	entryY, exitY := axisEntry(box.BottomLeft.Y, box.TopRight.Y, other.BottomLeft.Y, other.TopRight.Y, movement.Y)
+	entry := max(entryX, entryY)
+	exit := min(exitX, exitY)
+	if entry >= exit || entry > 1 || exit <= 0 {
+		return sweepHit{}, false
+	}
+
+	// Touching boxes may enter a rounding error before the start
+	hit = sweepHit{time: max(entry, 0)}
+	switch {
+	case math.Abs(entryX-entryY) <= contactEpsilon:
+		// Exactly into the corner
+		hit.blocksX, hit.blocksY = true, true
+	case entryX > entryY:
+		hit.blocksX = true
+	default:
+		hit.blocksY = true
+	}
+	return hit, true
+}
+
+// sweptBounds is the area the box passes through while moving.
+func sweptBounds(box types.CollisionBox, movement types.Vector) types.CollisionBox {
+	moved := box.Add(movement)
+	return types.CollisionBox{
+		BottomLeft: types.Vector{
+			X: min(box.BottomLeft.X, moved.BottomLeft.X),
+			Y: min(box.BottomLeft.Y, moved.BottomLeft.Y),
+		},
+		TopRight: types.Vector{
+			X: max(box.TopRight.X, moved.TopRight.X),
+			Y: max(box.TopRight.Y, moved.TopRight.Y),
+		},
+		IsRigid: box.IsRigid,
+	}
+}
@@ -0,0 +1,94 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+func TestSweepAABB(t *testing.T) {
+	floor := box(-10, 0, 20, 1)
+	tests := []struct {
+		name     string
+		box      types.CollisionBox
+		movement types.Vector
+		other    types.CollisionBox
+		hit      bool
+		time     float64
+		blocksX  bool
+		blocksY  bool
+	}{
+		{"free movement", box(0, 5, 1, 1), types.Vector{X: 3, Y: 0}, floor, false, 0, false, false},
+		{"into a wall", box(0, 0, 1, 1), types.Vector{X: 8, Y: 0}, box(5, -5, 1, 10), true, 0.5, true, false},
+		{"too short to reach", box(0, 0, 1, 1), types.Vector{X: 1, Y: 0}, box(5, -5, 1, 10), false, 0, false, false},
+		{"exact corner", box(0, 0, 1, 1), types.Vector{X: 2, Y: 2}, box(2, 2, 1, 1), true, 0.5, true, true},
+		{"just past a corner", box(0, 0, 1, 1), types.Vector{X: 2, Y: 2}, box(2.5, 0, 1, 1), false, 0, false, false},
+		{"touching, moving in", box(0, 0, 1, 1), types.Vector{X: 1, Y: 0}, box(1, 0, 1, 1), true, 0, true, false},
+		{"touching, moving away", box(0, 0, 1, 1), types.Vector{X: -1, Y: 0}, box(1, 0, 1, 1), false, 0, false, false},
+		{"sliding along the floor", box(0, 1, 1, 1), types.Vector{X: 3, Y: 0}, floor, false, 0, false, false},
+		{"standing on the floor", box(0, 1, 1, 1), types.Vector{X: 3, Y: -1}, floor, true, 0, false, true},
+		{"diagonal onto the floor", box(0, 2, 1, 1), types.Vector{X: 2, Y: -4}, floor, true, 0.25, false, true},
+		{"diagonal into a wall", box(0, 0, 1, 1), types.Vector{X: 4, Y: 1}, box(3, -10, 1, 30), true, 0.5, true, false},
+		{"overlapping, moving deeper", box(0, 0, 1, 1), types.Vector{X: 1, Y: 0}, box(0.5, 0.5, 1, 1), true, 0, true, false},
+		{"overlapping, deeper on both axes", box(0, 0, 1, 1), types.Vector{X: 1, Y: 1}, box(0.5, 0.5, 1, 1), true, 0, true, true},
+		{"overlapping, moving apart", box(0, 0, 1, 1), types.Vector{X: -1, Y: 0}, box(0.5, 0.5, 1, 1), false, 0, false, false},
+		{"overlapping, deeper on one axis only", box(0, 0, 1, 1), types.Vector{X: -1, Y: 1}, box(0.5, 0.5, 1, 1), true, 0, false, true},
+		{"same box", box(1, 1, 1, 1), types.Vector{X: 1, Y: -1}, box(1, 1, 1, 1), false, 0, false, false},
+		{"inside a larger box", box(1, 1, 1, 1), types.Vector{X: 0.5, Y: 0}, box(0, 0, 5, 5), false, 0, false, false},
+		{"tunnelling through a thin wall", box(0, 0, 1, 1), types.Vector{X: 10, Y: 0}, box(5, 0, 0.1, 1), true, 0.4, true, false},
+		{"floating point noise overlap", box(0, 1-1e-12, 1, 1), types.Vector{X: 3, Y: 0}, floor, false, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit, ok := sweepAABB(tt.box, tt.movement, tt.other)
+			if ok != tt.hit {
+				t.Fatalf("hit %v, want %v", ok, tt.hit)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(hit.time-tt.time) > 1e-6 {
+				t.Errorf("time %v, want %v", hit.time, tt.time)
+			}
+			if hit.blocksX != tt.blocksX || hit.blocksY != tt.blocksY {
+				t.Errorf("blocks X %v Y %v, want X %v Y %v", hit.blocksX, hit.blocksY, tt.blocksX, tt.blocksY)
+			}
+		})
+	}
+}
+
+func TestMoveObject(t *testing.T) {
+	mapObjects := []types.MapObject{
+		{Position: types.Vector{X: -10, Y: -1}, CollisionArea: types.CollisionArea{X: 40, Y: 1}, Mask: types.CL_ALL},
+		{Position: types.Vector{X: 5, Y: 0}, CollisionArea: types.CollisionArea{X: 1, Y: 20}, Mask: types.CL_ALL},
+	}
+	tests := []struct {
+		name     string
+		position types.Vector
+		speed    types.Vector
+		want     types.Vector
+		landed   bool
+	}{
+		// Player is 0.9 wide, moves are speed / 25 per tick
+		{"hugs the wall", types.Vector{X: 0, Y: 2}, types.Vector{X: 250, Y: 0}, types.Vector{X: 4.1, Y: 2}, false},
+		{"slides up the wall", types.Vector{X: 0, Y: 2}, types.Vector{X: 250, Y: 50}, types.Vector{X: 4.1, Y: 4}, false},
+		{"lands on the floor", types.Vector{X: 0, Y: 2}, types.Vector{X: 0, Y: -100}, types.Vector{X: 0, Y: 0}, true},
+		{"slides along the floor", types.Vector{X: 0, Y: 0}, types.Vector{X: 25, Y: -25}, types.Vector{X: 1, Y: 0}, true},
+		{"into the corner", types.Vector{X: 3, Y: 1}, types.Vector{X: 100, Y: -100}, types.Vector{X: 4.1, Y: 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(mapObjects, Config{Seed: 1})
+			player := &types.Player{Position: tt.position, Speed: tt.speed, CollisionArea: types.CollisionArea{X: 0.9, Y: 0.9}}
+			_, landed := s.moveObject(player, s.dt)
+			got := player.Position
+			if math.Abs(got.X-tt.want.X) > 1e-6 || math.Abs(got.Y-tt.want.Y) > 1e-6 {
+				t.Errorf("position %s, want %s", got.ToString(), tt.want.ToString())
+			}
+			if landed != tt.landed {
+				t.Errorf("landed %v, want %v", landed, tt.landed)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package simulation
+
+import (
+	"slices"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// objectKey tells players and projectiles apart, their IDs overlap.
+type objectKey struct {
+	layer types.CollisionLayer
+	id    types.ObjectID
+}
+
+func keyOf(obj types.MovableObject) objectKey {
+	return objectKey{layer: obj.GetCollisionLayer(), id: obj.GetID()}
+}
+
+// updateTriggers fires enter and exit of the triggers the object is in
+// after moving from the box. A trigger passed through during the move
+// fires both, so a fast object can't skip a thin one. Objects removed from
+// the game don't fire exit.
+func (s *Simulation) updateTriggers(obj types.MovableObject, from types.CollisionBox) {
+	key := keyOf(obj)
+	to := obj.GetCollisionArea().ToCollisionBox(obj.GetPosition())
+	movement := to.BottomLeft.Sub(from.BottomLeft)
+	previous := s.triggerContacts[key]
+
+	inside := []int{}
+	passed := []int{}
+	s.mapGrid.query(sweptBounds(from, movement), func(i int) bool {
+		mo := &s.state.MapObjects[i]
+		if !mo.Triggers(key.layer) {
+			return true
+		}
+		box := mo.GetCollisionBox()
+		if box.IntersectsWith(to) {
+			inside = append(inside, i)
+		} else if _, ok := sweepAABB(from, movement, box); ok && !slices.Contains(previous, i) {
+			passed = append(passed, i)
+		}
+		return true
+	})
+
+	if len(inside) == 0 {
+		delete(s.triggerContacts, key)
+	} else {
+		s.triggerContacts[key] = inside
+	}
+	for _, i := range previous {
+		if !slices.Contains(inside, i) {
+			s.onTrigger(types.ET_TRIGGER_EXIT, i, obj)
+		}
+	}
+	for _, i := range passed {
+		s.onTrigger(types.ET_TRIGGER_ENTER, i, obj)
+		s.onTrigger(types.ET_TRIGGER_EXIT, i, obj)
+	}
+	for _, i := range inside {
+		if !slices.Contains(previous, i) {
+			s.onTrigger(types.ET_TRIGGER_ENTER, i, obj)
+		}
+	}
+}
+
+// onTrigger reports an object entering or leaving the trigger, to the
+// handler and for players as an event, and applies the action of the
+// trigger on enter.
+func (s *Simulation) onTrigger(eventType types.EventType, trigger int, obj types.MovableObject) {
+	mo := &s.state.MapObjects[trigger]
+	if player, ok := obj.(*types.Player); ok {
+		s.addPlayerEvent(types.GameEvent{
+			Type:     eventType,
+			PlayerID: player.ID,
+			ObjectID: types.ObjectID(trigger),
+			Text:     mo.Name,
+		})
+	}
+	if s.triggerHandler != nil {
+		s.triggerHandler(eventType, trigger, obj)
+	}
+	if eventType != types.ET_TRIGGER_ENTER {
+		return
+	}
+
+	switch mo.Action {
+	case types.TA_KILL:
+		switch obj := obj.(type) {
+		case *types.Player:
+			// The death is handled with the other ones of the tick
+			obj.HP = 0
+		case *types.Projectile:
+			s.removeProjectile(obj.ID)
+		}
+	}
+}
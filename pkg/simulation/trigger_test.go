@@ -0,0 +1,117 @@
+package simulation
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// triggerMap is a floor with a kill zone standing on it and a thin sensor
+// in the air.
+var triggerMap = []types.MapObject{
+	{Position: types.Vector{X: -10, Y: -1}, CollisionArea: types.CollisionArea{X: 60, Y: 1}, Mask: types.CL_ALL},
+	{Position: types.Vector{X: 20, Y: 0}, CollisionArea: types.CollisionArea{X: 2, Y: 2}, Mask: types.CL_ALL, IsTrigger: true, Action: types.TA_KILL, Name: "lava"},
+	{Position: types.Vector{X: 3, Y: 4}, CollisionArea: types.CollisionArea{X: 0.1, Y: 3}, Mask: types.CL_ALL, IsTrigger: true, Name: "sensor"},
+}
+
+type triggerCall struct {
+	eventType types.EventType
+	trigger   int
+	key       objectKey
+}
+
+func recordTriggers(calls *[]triggerCall) TriggerHandler {
+	return func(eventType types.EventType, trigger int, obj types.MovableObject) {
+		*calls = append(*calls, triggerCall{eventType, trigger, keyOf(obj)})
+	}
+}
+
+func TestTriggerHandlerProjectile(t *testing.T) {
+	calls := []triggerCall{}
+	s := New(triggerMap, Config{Seed: 1, OnTrigger: recordTriggers(&calls)})
+	// Fast enough to pass the sensor within a tick
+	s.addProjectile(0, types.Vector{X: 0, Y: 5}, types.Vector{X: 50, Y: 0})
+	for range 5 {
+		s.Step()
+	}
+
+	key := objectKey{layer: types.CL_PROJECTILE, id: 0}
+	want := []triggerCall{
+		{types.ET_TRIGGER_ENTER, 2, key},
+		{types.ET_TRIGGER_EXIT, 2, key},
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+}
+
+func TestKillZoneProjectile(t *testing.T) {
+	calls := []triggerCall{}
+	s := New(triggerMap, Config{Seed: 1, OnTrigger: recordTriggers(&calls)})
+	s.addProjectile(0, types.Vector{X: 17, Y: 0.5}, types.Vector{X: 50, Y: 0})
+	for range 5 {
+		s.Step()
+	}
+
+	if len(s.state.Projectiles) != 0 {
+		t.Errorf("projectile survived the kill zone")
+	}
+	// Removed on enter, so there is no exit
+	want := []triggerCall{{types.ET_TRIGGER_ENTER, 1, objectKey{layer: types.CL_PROJECTILE, id: 0}}}
+	if !slices.Equal(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+}
+
+func TestKillZonePlayer(t *testing.T) {
+	tests := []struct {
+		name string
+		// Ticks since the last hit when the player walks in, zero for
+		// never hit. The credit period is 125 ticks at the default rate.
+		hitAgo types.GameTick
+		credit bool
+	}{
+		{"never hit", 0, false},
+		{"hit just before", 1, true},
+		{"hit at the end of the period", 125, true},
+		{"hit long ago", 126, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []triggerCall{}
+			s := New(triggerMap, Config{Seed: 1, OnTrigger: recordTriggers(&calls)})
+			shooter := s.AddPlayer("shooter")
+			victim := s.AddPlayer("victim")
+			for range 200 {
+				s.Step()
+			}
+			player := s.state.Players[victim]
+			if tt.hitAgo != 0 {
+				player.LastHitBy = shooter
+				player.LastHitTick = s.Tick() + 1 - tt.hitAgo
+			}
+			player.Position = types.Vector{X: 20.5, Y: 0}
+			s.TakeEvents()
+			s.Step()
+
+			if _, ok := s.state.Players[victim]; ok {
+				t.Fatalf("player survived the kill zone")
+			}
+			if !slices.Equal(calls, []triggerCall{{types.ET_TRIGGER_ENTER, 1, keyOf(player)}}) {
+				t.Errorf("handler calls %v", calls)
+			}
+			events := s.TakeEvents()
+			if len(events) < 2 || events[0].Type != types.ET_TRIGGER_ENTER || events[0].Text != "lava" || events[1].Type != types.ET_DEATH {
+				t.Fatalf("events %+v", events)
+			}
+			death := events[1]
+			if tt.credit && (death.ShooterID != shooter || death.ShooterName != "shooter") {
+				t.Errorf("death credited to %d %q, want %d", death.ShooterID, death.ShooterName, shooter)
+			}
+			if !tt.credit && death.ShooterName != "" {
+				t.Errorf("death credited to %d %q, want nobody", death.ShooterID, death.ShooterName)
+			}
+		})
+	}
+}
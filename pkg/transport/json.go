@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// JSON messages are bigger than binary ones, a line may take a few times
+// the binary payload limit
+const maxJSONLineSize = 4 * types.MaxPayloadSize
+
+// JSONConn sends messages as newline delimited JSON over a stream, see
+// types.JSONMessage. It is meant for debugging with nc and scripts.
+type JSONConn struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	writeMu sync.Mutex
+}
+
+func NewJSONConn(conn net.Conn) *JSONConn {
+	return newJSONConn(conn, bufio.NewReader(conn))
+}
+
+func newJSONConn(conn net.Conn, reader *bufio.Reader) *JSONConn {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxJSONLineSize)
+	return &JSONConn{conn: conn, scanner: scanner}
+}
+
+// IsJSON tells whether the connection speaks the JSON debug protocol. A
+// connection that detects the protocol knows it after the first message.
+func IsJSON(conn Conn) bool {
+	switch conn := conn.(type) {
+	case *JSONConn:
+		return true
+	case *detectConn:
+		_, ok := conn.current().(*JSONConn)
+		return ok
+	}
+	return false
+}
+
+func (jc *JSONConn) ReadMessage() (types.Message, error) {
+	for jc.scanner.Scan() {
+		line := bytes.TrimSpace(jc.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		return types.MessageFromJSON(line)
+	}
+	err := jc.scanner.Err()
+	if err == nil {
+		err = ErrClosed
+	}
+	return types.Message{}, err
+}
+
+func (jc *JSONConn) WriteMessage(msg types.Message) error {
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("encoding %s as JSON: %w", msg.Type.ToString(), err)
+	}
+
+	jc.writeMu.Lock()
+	defer jc.writeMu.Unlock()
+	_, err = jc.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (jc *JSONConn) SetReadDeadline(t time.Time) error {
+	return jc.conn.SetReadDeadline(t)
+}
+
+func (jc *JSONConn) RemoteAddr() net.Addr {
+	return jc.conn.RemoteAddr()
+}
+
+func (jc *JSONConn) Close() error {
+	return jc.conn.Close()
+}
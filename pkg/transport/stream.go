@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// StreamConn sends frames over a reliable ordered stream, such as TCP.
+type StreamConn struct {
+	conn    net.Conn
+	reader  io.Reader
+	writeMu sync.Mutex
+}
+
+func NewStreamConn(conn net.Conn) *StreamConn {
+	return &StreamConn{conn: conn, reader: conn}
+}
+
+func (sc *StreamConn) ReadMessage() (types.Message, error) {
+	return types.ReadMessage(sc.reader)
+}
+
+func (sc *StreamConn) WriteMessage(msg types.Message) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	_, err := sc.conn.Write(msg.ToBytes())
+	return err
+}
+
+func (sc *StreamConn) SetReadDeadline(t time.Time) error {
+	return sc.conn.SetReadDeadline(t)
+}
+
+func (sc *StreamConn) RemoteAddr() net.Addr {
+	return sc.conn.RemoteAddr()
+}
+
+func (sc *StreamConn) Close() error {
+	return sc.conn.Close()
+}
+
+// detectConn picks the framing by the first byte sent by the client. JSON
+// messages start with '{', which is not a valid binary message type.
+// Until the client sends something, messages are written as binary.
+type detectConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	mu     sync.Mutex
+	framed Conn
+}
+
+func newDetectConn(conn net.Conn) *detectConn {
+	return &detectConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (dc *detectConn) current() Conn {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.framed
+}
+
+func (dc *detectConn) ReadMessage() (types.Message, error) {
+	framed := dc.current()
+	if framed == nil {
+		first, err := dc.reader.Peek(1)
+		if err != nil {
+			return types.Message{}, err
+		}
+		if first[0] == '{' {
+			framed = newJSONConn(dc.conn, dc.reader)
+		} else {
+			framed = &StreamConn{conn: dc.conn, reader: dc.reader}
+		}
+		dc.mu.Lock()
+		dc.framed = framed
+		dc.mu.Unlock()
+	}
+	return framed.ReadMessage()
+}
+
+func (dc *detectConn) WriteMessage(msg types.Message) error {
+	framed := dc.current()
+	if framed == nil {
+		_, err := dc.conn.Write(msg.ToBytes())
+		return err
+	}
+	return framed.WriteMessage(msg)
+}
+
+func (dc *detectConn) SetReadDeadline(t time.Time) error {
+	return dc.conn.SetReadDeadline(t)
+}
+
+func (dc *detectConn) RemoteAddr() net.Addr {
+	return dc.conn.RemoteAddr()
+}
+
+func (dc *detectConn) Close() error {
+	return dc.conn.Close()
+}
+
+type streamListener struct {
+	listener   net.Listener
+	detectJSON bool
+}
+
+// ListenTCP accepts stream connections on the address.
+func ListenTCP(addr string) (Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &streamListener{listener: listener}, nil
+}
+
+// ListenTCPWithJSON is ListenTCP that also accepts clients speaking the
+// JSON debug protocol, see JSONConn.
+func ListenTCPWithJSON(addr string) (Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &streamListener{listener: listener, detectJSON: true}, nil
+}
+
+func (sl *streamListener) Accept() (Conn, error) {
+	conn, err := sl.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if sl.detectJSON {
+		return newDetectConn(conn), nil
+	}
+	return NewStreamConn(conn), nil
+}
+
+func (sl *streamListener) Addr() net.Addr {
+	return sl.listener.Addr()
+}
+
+func (sl *streamListener) Close() error {
+	return sl.listener.Close()
+}
+
+func DialTCP(addr string) (Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConn(conn), nil
+}
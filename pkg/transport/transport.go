@@ -0,0 +1,31 @@
+// Package transport carries framed messages between client and server.
+// The engine only sees Conn, so it doesn't care whether a player is
+// connected over TCP or UDP.
+package transport
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+var ErrClosed = errors.New("connection closed")
+var ErrTimeout = errors.New("connection timed out")
+
+// Conn is a message oriented connection. ReadMessage must be called from
+// a single goroutine, WriteMessage is safe for concurrent use.
+type Conn interface {
+	ReadMessage() (types.Message, error)
+	WriteMessage(types.Message) error
+	SetReadDeadline(time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+type Listener interface {
+	Accept() (Conn, error)
+	Addr() net.Addr
+	Close() error
+}
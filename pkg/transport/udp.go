@@ -0,0 +1,675 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// Packet layout:
+//
+//	[packet seq: 4][reliable ack: 4] entries...
+//
+// Every entry is [reliable seq: 4][frame]. Reliable seq 0 marks an
+// unreliable entry. Reliable ack is the next reliable seq the sender of the
+// packet expects to receive.
+//
+// Snapshots are unreliable and newest-wins: a snapshot from a packet older
+// than the last delivered one is dropped. Everything else is reliable: it
+// is repeated in every outgoing packet until the other side acks it, and
+// delivered exactly once and in order. Reliable entries go before the
+// unreliable one, so a snapshot never overtakes a message sent before it,
+// e.g. the initialization data.
+//
+// A frame that doesn't fit into a datagram with the headers (about 64 KiB)
+// is split into fragments, one entry each:
+//
+//	[reliable seq | fragmentFlag: 4][index: 2][count: 2][length: 2][chunk]
+//
+// Fragments of a reliable frame share its seq and are resent until the
+// whole frame is acked. Fragments of an unreliable frame go in consecutive
+// packets, the frame is known by the packet seq of its first fragment and
+// is lost with any of its fragments.
+const (
+	udpHeaderSize      = 8
+	maxDatagramSize    = 65507
+	udpTickInterval    = 20 * time.Millisecond
+	udpResendInterval  = 100 * time.Millisecond
+	udpKeepAlive       = time.Second
+	UDPTimeout         = 10 * time.Second
+	maxUnackedMessages = 1024
+	udpMessageBuffer   = 256
+	udpAcceptBacklog   = 16
+
+	fragmentFlag       = 1 << 31
+	fragmentHeaderSize = 4 + 6
+	maxFragmentChunk   = maxDatagramSize - udpHeaderSize - fragmentHeaderSize
+	// Enough for the largest payload a reader accepts and its frame header
+	maxFragmentCount = types.MaxPayloadSize/maxFragmentChunk + 1
+	// Reliable frames being reassembled at once, fragments of further ones
+	// are dropped and come again with the resend
+	maxPartialFrames = 4
+)
+
+var ErrMessageTooLarge = errors.New("message is too large")
+
+func isReliable(msg types.Message) bool {
+	messageType := msg.Type
+	if messageType == types.MT_COMPRESSED && len(msg.Payload) > 0 {
+		messageType = types.MessageType(msg.Payload[0])
+	}
+	switch messageType {
+	case types.MT_GAME_STATE, types.MT_GAME_STATE_DELTA, types.MT_ACK:
+		return false
+	}
+	return true
+}
+
+// encodeEntries returns the frame as a single entry, or as fragments if it
+// doesn't fit into a datagram.
+func encodeEntries(seq uint32, frame []byte) [][]byte {
+	if udpHeaderSize+4+len(frame) <= maxDatagramSize {
+		entry := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(frame)), seq)
+		return [][]byte{append(entry, frame...)}
+	}
+	count := (len(frame) + maxFragmentChunk - 1) / maxFragmentChunk
+	entries := make([][]byte, 0, count)
+	for index := range count {
+		chunk := frame[index*maxFragmentChunk : min(len(frame), (index+1)*maxFragmentChunk)]
+		entry := make([]byte, 0, fragmentHeaderSize+len(chunk))
+		entry = binary.BigEndian.AppendUint32(entry, seq|fragmentFlag)
+		entry = binary.BigEndian.AppendUint16(entry, uint16(index))
+		entry = binary.BigEndian.AppendUint16(entry, uint16(count))
+		entry = binary.BigEndian.AppendUint16(entry, uint16(len(chunk)))
+		entries = append(entries, append(entry, chunk...))
+	}
+	return entries
+}
+
+type fragment struct {
+	index uint16
+	count uint16
+	chunk []byte
+}
+
+func readFragment(reader *bytes.Reader) (fragment, error) {
+	header := [6]byte{}
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		return fragment{}, err
+	}
+	f := fragment{
+		index: binary.BigEndian.Uint16(header[0:2]),
+		count: binary.BigEndian.Uint16(header[2:4]),
+		chunk: make([]byte, binary.BigEndian.Uint16(header[4:6])),
+	}
+	if f.count == 0 || f.count > maxFragmentCount || f.index >= f.count {
+		return fragment{}, types.ErrMalformedMessage
+	}
+	_, err = io.ReadFull(reader, f.chunk)
+	return f, err
+}
+
+// partialFrame collects the fragments of a frame.
+type partialFrame struct {
+	chunks  [][]byte
+	missing int
+}
+
+func newPartialFrame(count uint16) *partialFrame {
+	return &partialFrame{chunks: make([][]byte, count), missing: int(count)}
+}
+
+// add returns the message once all fragments are there. Fragments that
+// don't belong to the frame are ignored.
+func (p *partialFrame) add(f fragment) (types.Message, bool) {
+	if int(f.count) != len(p.chunks) || p.chunks[f.index] != nil {
+		return types.Message{}, false
+	}
+	p.chunks[f.index] = f.chunk
+	p.missing--
+	if p.missing > 0 {
+		return types.Message{}, false
+	}
+	msg, err := types.ReadMessage(bytes.NewReader(bytes.Join(p.chunks, nil)))
+	return msg, err == nil
+}
+
+type reliableMessage struct {
+	seq uint32
+	// Encoded entry, one of the fragments if the frame is split
+	entry []byte
+}
+
+type udpConn struct {
+	remote  net.Addr
+	send    func([]byte) error
+	onClose func()
+
+	incoming chan []byte
+	messages chan types.Message
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu               sync.Mutex
+	nextPacketSeq    uint32
+	nextReliableSeq  uint32
+	unacked          []reliableMessage
+	recvNextReliable uint32
+	ackPending       bool
+	lastSent         time.Time
+	lastResent       time.Time
+	readDeadline     time.Time
+
+	// Only touched by the run loop
+	pendingReliable   map[uint32]types.Message
+	lastUnreliableSeq uint32
+	lastReceived      time.Time
+	// Received messages waiting for the reader, at most udpMessageBuffer
+	ready []types.Message
+	// Frames being reassembled, reliable ones by seq
+	reliableFragments   map[uint32]*partialFrame
+	unreliableFragments *partialFrame
+	// Packet seq of the first fragment of unreliableFragments
+	unreliableFragmentsSeq uint32
+}
+
+func newUDPConn(remote net.Addr, send func([]byte) error, onClose func()) *udpConn {
+	c := &udpConn{
+		remote:            remote,
+		send:              send,
+		onClose:           onClose,
+		incoming:          make(chan []byte, udpMessageBuffer),
+		messages:          make(chan types.Message, udpMessageBuffer),
+		closed:            make(chan struct{}),
+		nextPacketSeq:     1,
+		nextReliableSeq:   1,
+		recvNextReliable:  1,
+		pendingReliable:   map[uint32]types.Message{},
+		lastReceived:      time.Now(),
+		reliableFragments: map[uint32]*partialFrame{},
+	}
+	go c.run()
+	return c
+}
+
+// run never waits for the reader, received messages queue up in ready so
+// that acks, resends and keepalives go on.
+func (c *udpConn) run() {
+	ticker := time.NewTicker(udpTickInterval)
+	defer ticker.Stop()
+
+	for {
+		var deliver chan<- types.Message
+		var next types.Message
+		if len(c.ready) > 0 {
+			deliver = c.messages
+			next = c.ready[0]
+		}
+
+		select {
+		case deliver <- next:
+			c.ready = c.ready[1:]
+		case packet := <-c.incoming:
+			c.lastReceived = time.Now()
+			c.receive(packet)
+		case <-ticker.C:
+			if time.Since(c.lastReceived) > UDPTimeout {
+				c.closeWithError(ErrTimeout)
+				return
+			}
+			err := c.flush()
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+type udpEntry struct {
+	seq uint32
+	// Packet seq that orders unreliable entries
+	packetSeq uint32
+	msg       types.Message
+	// A fragment of a reliable frame that is not complete yet
+	partial bool
+}
+
+// readEntries parses the entries of a packet and reassembles fragmented
+// frames.
+func (c *udpConn) readEntries(packetSeq uint32, reader *bytes.Reader) ([]udpEntry, error) {
+	entries := []udpEntry{}
+	for reader.Len() > 0 {
+		seqBuff := [4]byte{}
+		_, err := io.ReadFull(reader, seqBuff[:])
+		if err != nil {
+			return nil, err
+		}
+		seq := binary.BigEndian.Uint32(seqBuff[:])
+		if seq&fragmentFlag == 0 {
+			msg, err := types.ReadMessage(reader)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, udpEntry{seq: seq, packetSeq: packetSeq, msg: msg})
+			continue
+		}
+
+		seq &^= fragmentFlag
+		f, err := readFragment(reader)
+		if err != nil {
+			return nil, err
+		}
+		if seq != 0 {
+			msg, complete := c.addReliableFragment(seq, f)
+			entries = append(entries, udpEntry{seq: seq, msg: msg, partial: !complete})
+			continue
+		}
+		if uint32(f.index) >= packetSeq {
+			return nil, types.ErrMalformedMessage
+		}
+		firstSeq := packetSeq - uint32(f.index)
+		if msg, ok := c.addUnreliableFragment(firstSeq, f); ok {
+			// Ordered as the last fragment, the newest part of the frame
+			entries = append(entries, udpEntry{packetSeq: firstSeq + uint32(f.count) - 1, msg: msg})
+		}
+	}
+	return entries, nil
+}
+
+func (c *udpConn) addReliableFragment(seq uint32, f fragment) (types.Message, bool) {
+	if seq < c.recvNextReliable {
+		return types.Message{}, false
+	}
+	partial, ok := c.reliableFragments[seq]
+	if !ok {
+		if len(c.reliableFragments) >= maxPartialFrames {
+			return types.Message{}, false
+		}
+		partial = newPartialFrame(f.count)
+		c.reliableFragments[seq] = partial
+	}
+	msg, complete := partial.add(f)
+	if complete || partial.missing == 0 {
+		delete(c.reliableFragments, seq)
+	}
+	return msg, complete
+}
+
+// addUnreliableFragment keeps only the newest unreliable frame, fragments
+// of an older one mean it is already stale.
+func (c *udpConn) addUnreliableFragment(firstSeq uint32, f fragment) (types.Message, bool) {
+	if c.unreliableFragments == nil || firstSeq > c.unreliableFragmentsSeq {
+		c.unreliableFragments = newPartialFrame(f.count)
+		c.unreliableFragmentsSeq = firstSeq
+	}
+	if firstSeq != c.unreliableFragmentsSeq {
+		return types.Message{}, false
+	}
+	msg, complete := c.unreliableFragments.add(f)
+	if complete || c.unreliableFragments.missing == 0 {
+		c.unreliableFragments = nil
+	}
+	return msg, complete
+}
+
+// receive handles a single datagram. Malformed datagrams are dropped, same
+// as the network would do with them. So are reliable entries that don't
+// fit into ready, they come again with the resend.
+func (c *udpConn) receive(packet []byte) {
+	if len(packet) < udpHeaderSize {
+		return
+	}
+	packetSeq := binary.BigEndian.Uint32(packet[:4])
+	reliableAck := binary.BigEndian.Uint32(packet[4:8])
+
+	entries, err := c.readEntries(packetSeq, bytes.NewReader(packet[udpHeaderSize:]))
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.unacked) > 0 && c.unacked[0].seq < reliableAck {
+		c.unacked = c.unacked[1:]
+	}
+
+	for _, entry := range entries {
+		if entry.seq == 0 {
+			if entry.packetSeq <= c.lastUnreliableSeq || len(c.ready) >= udpMessageBuffer {
+				continue
+			}
+			c.lastUnreliableSeq = entry.packetSeq
+			c.ready = append(c.ready, entry.msg)
+			continue
+		}
+
+		c.ackPending = true
+		if entry.partial || entry.seq < c.recvNextReliable || len(c.ready) >= udpMessageBuffer {
+			continue
+		}
+		c.pendingReliable[entry.seq] = entry.msg
+		for len(c.ready) < udpMessageBuffer {
+			next, ok := c.pendingReliable[c.recvNextReliable]
+			if !ok {
+				break
+			}
+			delete(c.pendingReliable, c.recvNextReliable)
+			c.recvNextReliable++
+			c.ready = append(c.ready, next)
+		}
+	}
+}
+
+// buildPacket must be called with mu held. As many unacked reliable
+// entries from the given index on as fit into the datagram next to the
+// extra entry go first, the extra entry last. Returns the index of the
+// first reliable entry left out.
+func (c *udpConn) buildPacket(from int, extra []byte) ([]byte, int) {
+	packet := make([]byte, udpHeaderSize, 1500)
+	binary.BigEndian.PutUint32(packet[:4], c.nextPacketSeq)
+	binary.BigEndian.PutUint32(packet[4:8], c.recvNextReliable)
+	c.nextPacketSeq++
+
+	room := maxDatagramSize - len(extra)
+	next := from
+	for ; next < len(c.unacked); next++ {
+		entry := c.unacked[next].entry
+		if len(packet)+len(entry) > room {
+			break
+		}
+		packet = append(packet, entry...)
+	}
+	packet = append(packet, extra...)
+
+	c.ackPending = false
+	c.lastSent = time.Now()
+	return packet, next
+}
+
+// sendReliable must be called with mu held. It sends the unacked entries
+// from the given index on, in as many packets as needed.
+func (c *udpConn) sendReliable(from int) error {
+	for {
+		packet, next := c.buildPacket(from, nil)
+		err := c.send(packet)
+		if err != nil || next >= len(c.unacked) {
+			return err
+		}
+		from = next
+	}
+}
+
+func (c *udpConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resend := len(c.unacked) > 0 && time.Since(c.lastResent) > udpResendInterval
+	if resend {
+		c.lastResent = time.Now()
+		return c.sendReliable(0)
+	}
+	if c.ackPending || time.Since(c.lastSent) >= udpKeepAlive {
+		packet, _ := c.buildPacket(0, nil)
+		return c.send(packet)
+	}
+	return nil
+}
+
+func (c *udpConn) WriteMessage(msg types.Message) error {
+	select {
+	case <-c.closed:
+		return c.closeErr
+	default:
+	}
+
+	// The other side wouldn't read it
+	if len(msg.Payload) > types.MaxPayloadSize {
+		return fmt.Errorf("%w: %s of %d bytes", ErrMessageTooLarge, msg.Type.ToString(), len(msg.Payload))
+	}
+	frame := msg.ToBytes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !isReliable(msg) {
+		entries := encodeEntries(0, frame)
+		if len(entries) == 1 {
+			packet, _ := c.buildPacket(0, entries[0])
+			return c.send(packet)
+		}
+		// Consecutive packets, without reliable entries that would
+		// push fragments out
+		for _, entry := range entries {
+			packet, _ := c.buildPacket(len(c.unacked), entry)
+			err := c.send(packet)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(c.unacked) >= maxUnackedMessages {
+		return ErrTimeout
+	}
+	seq := c.nextReliableSeq
+	c.nextReliableSeq++
+	first := len(c.unacked)
+	for _, entry := range encodeEntries(seq, frame) {
+		c.unacked = append(c.unacked, reliableMessage{seq, entry})
+	}
+	c.lastResent = time.Now()
+	return c.sendReliable(first)
+}
+
+func (c *udpConn) ReadMessage() (types.Message, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-c.closed:
+		return types.Message{}, c.closeErr
+	case <-timeout:
+		return types.Message{}, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *udpConn) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+		c.onClose()
+	})
+}
+
+func (c *udpConn) Close() error {
+	c.closeWithError(ErrClosed)
+	return nil
+}
+
+type udpListener struct {
+	conn     *net.UDPConn
+	accept   chan Conn
+	closed   chan struct{}
+	mu       sync.Mutex
+	sessions map[string]*udpConn
+}
+
+// ListenUDP serves all UDP clients from a single socket, sessions are told
+// apart by the remote address. Datagrams from an address without a session
+// are ignored unless they open one.
+func ListenUDP(addr string) (Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{
+		conn:     conn,
+		accept:   make(chan Conn, udpAcceptBacklog),
+		closed:   make(chan struct{}),
+		sessions: map[string]*udpConn{},
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *udpListener) serve() {
+	buff := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buff)
+		if err != nil {
+			l.Close()
+			return
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		packet := bytes.Clone(buff[:n])
+
+		l.mu.Lock()
+		session, ok := l.sessions[addr.String()]
+		if !ok {
+			if !isSessionStart(packet) {
+				// A late or stray datagram of a session that is gone
+				l.mu.Unlock()
+				continue
+			}
+			if len(l.accept) == cap(l.accept) {
+				// Backlog is full, the client will retry
+				l.mu.Unlock()
+				continue
+			}
+			session = newUDPConn(
+				addr,
+				func(b []byte) error {
+					_, err := l.conn.WriteToUDP(b, addr)
+					return err
+				},
+				func() { l.removeSession(addr.String()) },
+			)
+			l.sessions[addr.String()] = session
+			l.accept <- session
+		}
+		l.mu.Unlock()
+
+		select {
+		case session.incoming <- packet:
+		default:
+		}
+	}
+}
+
+// isSessionStart tells whether the packet carries the first reliable
+// message of a client, which opens a session. It is the first entry until
+// the server acks it.
+func isSessionStart(packet []byte) bool {
+	if len(packet) < udpHeaderSize+4 {
+		return false
+	}
+	return binary.BigEndian.Uint32(packet[udpHeaderSize:])&^fragmentFlag == 1
+}
+
+func (l *udpListener) removeSession(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, key)
+}
+
+func (l *udpListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *udpListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.closed:
+		return nil
+	default:
+	}
+	close(l.closed)
+	return l.conn.Close()
+}
+
+func DialUDP(addr string) (Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newUDPConn(
+		udpAddr,
+		func(b []byte) error {
+			_, err := conn.Write(b)
+			return err
+		},
+		func() { conn.Close() },
+	)
+	go func() {
+		buff := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buff)
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+			select {
+			case c.incoming <- bytes.Clone(buff[:n]):
+			default:
+			}
+		}
+	}()
+	return c, nil
+}
@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// udpPair connects two sessions in memory, drop tells whether a datagram
+// from a to b is lost. Like the socket, a full incoming queue drops too.
+func udpPair(drop func(packet int) bool) (a *udpConn, b *udpConn) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8000}
+	sent := 0
+	deliver := func(conn **udpConn, packet []byte) {
+		select {
+		case (*conn).incoming <- packet:
+		default:
+		}
+	}
+	a = newUDPConn(addr, func(packet []byte) error {
+		sent++
+		if !drop(sent) {
+			deliver(&b, packet)
+		}
+		return nil
+	}, func() {})
+	b = newUDPConn(addr, func(packet []byte) error {
+		deliver(&a, packet)
+		return nil
+	}, func() {})
+	return a, b
+}
+
+// largeMessage doesn't fit into a datagram, its payload tells the position
+// of every byte.
+func largeMessage(messageType types.MessageType, size int) types.Message {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i / 7)
+	}
+	return types.NewMessage(messageType, payload)
+}
+
+func readMessage(t *testing.T, conn *udpConn) types.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestUDPReliableBeforeSnapshot(t *testing.T) {
+	// The datagram with the initialization data is lost, the snapshot one
+	// repeats it
+	a, b := udpPair(func(packet int) bool { return packet == 1 })
+	defer a.Close()
+	defer b.Close()
+
+	err := a.WriteMessage(types.InitializationData{PlayerID: 3}.ToMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.WriteMessage(types.GameState{TickNumber: 1}.ToMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []types.MessageType{types.MT_INITIALIZATION_DATA, types.MT_GAME_STATE} {
+		msg, err := b.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != want {
+			t.Fatalf("got %s, want %s", msg.Type.ToString(), want.ToString())
+		}
+	}
+}
+
+func TestUDPMessageTooLarge(t *testing.T) {
+	a, b := udpPair(func(int) bool { return false })
+	defer a.Close()
+	defer b.Close()
+
+	err := a.WriteMessage(types.NewMessage(types.MT_GAME_STATE, make([]byte, types.MaxPayloadSize+1)))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestUDPFragmentedSnapshot(t *testing.T) {
+	a, b := udpPair(func(int) bool { return false })
+	defer a.Close()
+	defer b.Close()
+
+	msg := largeMessage(types.MT_GAME_STATE, 3*maxDatagramSize)
+	err := a.WriteMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readMessage(t, b)
+	if got.Type != msg.Type || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Fatalf("got %s of %d bytes, want %s of %d bytes",
+			got.Type.ToString(), len(got.Payload), msg.Type.ToString(), len(msg.Payload))
+	}
+}
+
+func TestUDPLostFragmentDropsSnapshot(t *testing.T) {
+	a, b := udpPair(func(packet int) bool { return packet == 2 })
+	defer a.Close()
+	defer b.Close()
+
+	err := a.WriteMessage(largeMessage(types.MT_GAME_STATE, 3*maxDatagramSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.WriteMessage(types.GameState{TickNumber: 2}.ToMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readMessage(t, b)
+	if len(got.Payload) >= maxDatagramSize {
+		t.Fatalf("got the snapshot with a lost fragment")
+	}
+}
+
+func TestUDPFragmentedReliableResent(t *testing.T) {
+	// The middle fragment is lost on the first try
+	a, b := udpPair(func(packet int) bool { return packet == 2 })
+	defer a.Close()
+	defer b.Close()
+
+	msg := largeMessage(types.MT_INITIALIZATION_DATA, 3*maxDatagramSize)
+	for _, m := range []types.Message{msg, types.NewMessage(types.MT_CHAT, []byte("after"))} {
+		err := a.WriteMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readMessage(t, b)
+	if got.Type != msg.Type || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Fatalf("got %s of %d bytes, want the fragmented message", got.Type.ToString(), len(got.Payload))
+	}
+	if got := readMessage(t, b); got.Type != types.MT_CHAT {
+		t.Fatalf("got %s, want %s", got.Type.ToString(), types.MT_CHAT.ToString())
+	}
+}
+
+func TestUDPSlowReaderStillAcks(t *testing.T) {
+	a, b := udpPair(func(int) bool { return false })
+	defer a.Close()
+	defer b.Close()
+
+	// More than the reader side buffers, b must keep acking what it took
+	count := 2*udpMessageBuffer + 10
+	for i := range count {
+		err := a.WriteMessage(types.NewMessage(types.MT_CHAT, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	unacked := func() int {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.unacked)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for unacked() > count-2*udpMessageBuffer {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages still unacked", unacked())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := range count {
+		if got := readMessage(t, b); got.Payload[0] != byte(i) {
+			t.Fatalf("message %d: got %d", i, got.Payload[0])
+		}
+	}
+}
+
+func TestUDPListenerIgnoresStrayDatagrams(t *testing.T) {
+	listener, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// A keepalive of a client whose session is gone
+	stray, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stray.Close()
+	stray.Write([]byte{0, 0, 0, 9, 0, 0, 0, 4})
+	select {
+	case <-accepted:
+		t.Fatal("stray datagram opened a session")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	client, err := DialUDP(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	err = client.WriteMessage(types.NewMessage(types.MT_CLIENT_HELLO, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("client hello didn't open a session")
+	}
+}
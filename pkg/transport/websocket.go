@@ -0,0 +1,313 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// WebSocket support is limited to what the game needs: server side only,
+// binary messages, no extensions. Every frame written to the connection
+// becomes one binary WebSocket message, so browser tools receive exactly
+// the same framed messages as TCP clients.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	maxWSFrameSize = types.MaxPayloadSize + 64
+	// RFC 6455 section 5.5
+	maxWSControlPayload = 125
+)
+
+var ErrBadWebSocketFrame = errors.New("bad websocket frame")
+
+// wsConn adapts a WebSocket connection to net.Conn. Read returns payloads
+// of data messages, control frames are handled internally.
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+
+	// Unread rest of the current data frame
+	frameLeft int64
+	mask      [4]byte
+	maskPos   int
+	masked    bool
+}
+
+func (wc *wsConn) Read(b []byte) (int, error) {
+	for wc.frameLeft == 0 {
+		err := wc.nextDataFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(b)) > wc.frameLeft {
+		b = b[:wc.frameLeft]
+	}
+	n, err := wc.reader.Read(b)
+	if wc.masked {
+		for i := range n {
+			b[i] ^= wc.mask[wc.maskPos%4]
+			wc.maskPos++
+		}
+	}
+	wc.frameLeft -= int64(n)
+	return n, err
+}
+
+// nextDataFrame reads frame headers until it finds a data frame, answering
+// pings and closes along the way.
+func (wc *wsConn) nextDataFrame() error {
+	for {
+		header := [2]byte{}
+		_, err := io.ReadFull(wc.reader, header[:])
+		if err != nil {
+			return err
+		}
+		final := header[0]&0x80 != 0
+		opcode := header[0] & 0x0F
+		wc.masked = header[1]&0x80 != 0
+		length := int64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			ext := [2]byte{}
+			_, err = io.ReadFull(wc.reader, ext[:])
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			ext := [8]byte{}
+			_, err = io.ReadFull(wc.reader, ext[:])
+			length = int64(binary.BigEndian.Uint64(ext[:]))
+		}
+		if err != nil {
+			return err
+		}
+		if length < 0 || length > maxWSFrameSize {
+			return fmt.Errorf("%w: frame of %d bytes", ErrBadWebSocketFrame, length)
+		}
+		// Control frames are small and never fragmented
+		if opcode&0x08 != 0 && (length > maxWSControlPayload || !final) {
+			return fmt.Errorf("%w: control frame 0x%x of %d bytes, final %v", ErrBadWebSocketFrame, opcode, length, final)
+		}
+		// Clients must mask everything they send
+		if !wc.masked {
+			return fmt.Errorf("%w: unmasked client frame", ErrBadWebSocketFrame)
+		}
+		_, err = io.ReadFull(wc.reader, wc.mask[:])
+		if err != nil {
+			return err
+		}
+		wc.maskPos = 0
+
+		switch opcode {
+		case wsOpBinary, wsOpText, wsOpContinuation:
+			wc.frameLeft = length
+			return nil
+		case wsOpPing, wsOpPong, wsOpClose:
+			payload := make([]byte, length)
+			_, err := io.ReadFull(wc.reader, payload)
+			if err != nil {
+				return err
+			}
+			for i := range payload {
+				payload[i] ^= wc.mask[i%4]
+			}
+			if opcode == wsOpPing {
+				err = wc.writeFrame(wsOpPong, payload)
+				if err != nil {
+					return err
+				}
+			}
+			if opcode == wsOpClose {
+				wc.writeFrame(wsOpClose, nil)
+				return io.EOF
+			}
+		default:
+			return fmt.Errorf("%w: opcode 0x%x", ErrBadWebSocketFrame, opcode)
+		}
+	}
+}
+
+func (wc *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	wc.writeMu.Lock()
+	defer wc.writeMu.Unlock()
+	_, err := wc.conn.Write(frame)
+	return err
+}
+
+func (wc *wsConn) Write(b []byte) (int, error) {
+	err := wc.writeFrame(wsOpBinary, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (wc *wsConn) Close() error {
+	wc.writeFrame(wsOpClose, nil)
+	return wc.conn.Close()
+}
+
+func (wc *wsConn) LocalAddr() net.Addr                { return wc.conn.LocalAddr() }
+func (wc *wsConn) RemoteAddr() net.Addr               { return wc.conn.RemoteAddr() }
+func (wc *wsConn) SetDeadline(t time.Time) error      { return wc.conn.SetDeadline(t) }
+func (wc *wsConn) SetReadDeadline(t time.Time) error  { return wc.conn.SetReadDeadline(t) }
+func (wc *wsConn) SetWriteDeadline(t time.Time) error { return wc.conn.SetWriteDeadline(t) }
+
+// checkOrigin allows requests without Origin, which don't come from a
+// browser, and requests from pages of the same host. Other origins must be
+// listed in allowedOrigins, "*" allows any.
+func checkOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// UpgradeWebSocket performs the opening handshake and takes the connection
+// over from the HTTP server. It can be used from any HTTP handler, the
+// returned net.Conn goes to NewStreamConn. See checkOrigin for
+// allowedOrigins.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (net.Conn, error) {
+	if r.Method != http.MethodGet ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket request from %s", r.RemoteAddr)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version from %s", r.RemoteAddr)
+	}
+	if !checkOrigin(r, allowedOrigins) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket origin %q not allowed from %s", r.Header.Get("Origin"), r.RemoteAddr)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing websocket key from %s", r.RemoteAddr)
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade connection", http.StatusInternalServerError)
+		return nil, errors.New("http server does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+type wsListener struct {
+	listener net.Listener
+	server   *http.Server
+	accept   chan Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// ListenWebSocket accepts WebSocket connections on the given path. Each
+// binary message carries one frame, same as on TCP. Browser pages from
+// other hosts need their origin in allowedOrigins.
+func ListenWebSocket(addr string, path string, allowedOrigins []string) (Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wsListener{
+		listener: listener,
+		accept:   make(chan Conn),
+		closed:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := UpgradeWebSocket(w, r, allowedOrigins)
+		if err != nil {
+			return
+		}
+		select {
+		case wl.accept <- NewStreamConn(conn):
+		case <-wl.closed:
+			conn.Close()
+		}
+	})
+	wl.server = &http.Server{Handler: mux}
+	go func() {
+		wl.server.Serve(listener)
+		wl.Close()
+	}()
+	return wl, nil
+}
+
+func (wl *wsListener) Accept() (Conn, error) {
+	select {
+	case conn := <-wl.accept:
+		return conn, nil
+	case <-wl.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (wl *wsListener) Addr() net.Addr {
+	return wl.listener.Addr()
+}
+
+func (wl *wsListener) Close() error {
+	wl.once.Do(func() {
+		close(wl.closed)
+		wl.server.Close()
+	})
+	return nil
+}
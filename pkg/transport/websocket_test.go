@@ -0,0 +1,225 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types"
+)
+
+// Key and accept value from the example in RFC 6455 section 1.3
+const (
+	testWSKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	testWSAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+func listenWS(t *testing.T, allowedOrigins []string) Listener {
+	t.Helper()
+	listener, err := ListenWebSocket("127.0.0.1:0", "/ws", allowedOrigins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// dialWS sends the opening handshake, origin is left out if empty.
+func dialWS(t *testing.T, addr net.Addr, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	request := "GET /ws HTTP/1.1\r\n" +
+		"Host: " + addr.String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testWSKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	_, err = conn.Write([]byte(request + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response
+}
+
+// clientFrame builds a frame as a client sends it, masked.
+func clientFrame(final bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if final {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	header := [2]byte{}
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := int(header[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := [2]byte{}
+		io.ReadFull(reader, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		ext := [8]byte{}
+		io.ReadFull(reader, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func accept(t *testing.T, listener Listener) Conn {
+	t.Helper()
+	accepted := make(chan Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(time.Second):
+		t.Fatal("no connection accepted")
+		return nil
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	listener := listenWS(t, nil)
+	_, _, response := dialWS(t, listener.Addr(), "")
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := response.Header.Get("Sec-WebSocket-Accept"); got != testWSAccept {
+		t.Errorf("accept %q, want %q", got, testWSAccept)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	listener := listenWS(t, []string{"https://game.example"})
+	tests := []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{"no origin", "", http.StatusSwitchingProtocols},
+		{"same host", "http://" + listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"allowed", "https://game.example", http.StatusSwitchingProtocols},
+		{"other host", "https://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, response := dialWS(t, listener.Addr(), tt.origin)
+			if response.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", response.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	listener := listenWS(t, nil)
+	client, reader, _ := dialWS(t, listener.Addr(), "")
+	server := accept(t, listener)
+
+	// A message split over two frames, with a ping in between
+	sent := types.NewMessage(types.MT_CHAT, bytes.Repeat([]byte("hello "), 50))
+	frame := sent.ToBytes()
+	client.Write(clientFrame(false, wsOpBinary, frame[:100]))
+	client.Write(clientFrame(true, wsOpPing, []byte("ping")))
+	client.Write(clientFrame(true, wsOpContinuation, frame[100:]))
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != sent.Type || !bytes.Equal(got.Payload, sent.Payload) {
+		t.Errorf("server got %s %q", got.Type.ToString(), got.Payload)
+	}
+	if opcode, payload := readServerFrame(t, reader); opcode != wsOpPong || string(payload) != "ping" {
+		t.Errorf("got opcode 0x%x %q, want pong %q", opcode, payload, "ping")
+	}
+
+	reply := types.NewMessage(types.MT_EVENTS, bytes.Repeat([]byte{7}, 300))
+	err = server.WriteMessage(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload := readServerFrame(t, reader); opcode != wsOpBinary || !bytes.Equal(payload, reply.ToBytes()) {
+		t.Errorf("client got opcode 0x%x with %d bytes", opcode, len(payload))
+	}
+}
+
+func TestWebSocketBadFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"control frame over 125 bytes", clientFrame(true, wsOpPing, make([]byte, 126))},
+		{"fragmented control frame", clientFrame(false, wsOpPing, []byte("ping"))},
+		{"unmasked frame", []byte{0x80 | wsOpBinary, 1, 0}},
+		{"unknown opcode", clientFrame(true, 0x3, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := listenWS(t, nil)
+			client, _, _ := dialWS(t, listener.Addr(), "")
+			server := accept(t, listener)
+
+			client.Write(tt.frame)
+			server.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := server.ReadMessage()
+			if !errors.Is(err, ErrBadWebSocketFrame) {
+				t.Fatalf("got %v, want ErrBadWebSocketFrame", err)
+			}
+		})
+	}
+}
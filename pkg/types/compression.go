@@ -0,0 +1,115 @@
+package types
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression is negotiated during the handshake: the client lists what it
+// can decode in ClientHello, the server picks one and announces it in
+// InitializationData.
+type Compression byte
+
+const (
+	C_NONE    Compression = 0x00
+	C_DEFLATE Compression = 0x01
+)
+
+// SupportedCompressions is what this build can decode.
+var SupportedCompressions = []Compression{C_DEFLATE}
+
+func (c Compression) ToString() string {
+	switch c {
+	case C_NONE:
+		return "none"
+	case C_DEFLATE:
+		return "deflate"
+	}
+	return fmt.Sprintf("unknown compression 0x%02x", byte(c))
+}
+
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return C_NONE, nil
+	case "deflate":
+		return C_DEFLATE, nil
+	}
+	return C_NONE, fmt.Errorf("unknown compression %q", s)
+}
+
+// Compressor packs messages of one connection into MT_COMPRESSED frames:
+//
+//	[inner message type: 1][compressed payload]
+//
+// Every message is compressed on its own, so frames can be lost or
+// reordered by the transport. Not safe for concurrent use.
+type Compressor struct {
+	compression Compression
+	buff        bytes.Buffer
+	writer      *flate.Writer
+}
+
+func NewCompressor(compression Compression) *Compressor {
+	c := &Compressor{compression: compression}
+	if compression == C_DEFLATE {
+		c.writer, _ = flate.NewWriter(&c.buff, flate.BestSpeed)
+	}
+	return c
+}
+
+func (c *Compressor) Compression() Compression {
+	return c.compression
+}
+
+// Compress returns the message unchanged if compression is off or doesn't
+// make it any smaller.
+func (c *Compressor) Compress(msg Message) Message {
+	if c.compression != C_DEFLATE {
+		return msg
+	}
+
+	c.buff.Reset()
+	c.buff.WriteByte(byte(msg.Type))
+	c.writer.Reset(&c.buff)
+	c.writer.Write(msg.Payload)
+	c.writer.Close()
+	if c.buff.Len() >= len(msg.Payload) {
+		return msg
+	}
+	return Message{
+		Type:    MT_COMPRESSED,
+		Version: msg.Version,
+		Payload: bytes.Clone(c.buff.Bytes()),
+	}
+}
+
+// Decompress unpacks an MT_COMPRESSED message, other messages are returned
+// as they are.
+func Decompress(msg Message) (Message, error) {
+	if msg.Type != MT_COMPRESSED {
+		return msg, nil
+	}
+	if len(msg.Payload) == 0 {
+		return Message{}, fmt.Errorf("%w: empty compressed message", ErrMalformedMessage)
+	}
+	innerType := MessageType(msg.Payload[0])
+	if innerType == MT_COMPRESSED {
+		return Message{}, fmt.Errorf("%w: nested compressed message", ErrMalformedMessage)
+	}
+
+	reader := flate.NewReader(bytes.NewReader(msg.Payload[1:]))
+	defer reader.Close()
+	// Same limit as for plain frames, a tiny payload may inflate to gigabytes
+	payload, err := io.ReadAll(io.LimitReader(reader, MaxPayloadSize+1))
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: decompressing %s: %v", ErrMalformedMessage, innerType.ToString(), err)
+	}
+	if len(payload) > MaxPayloadSize {
+		return Message{}, fmt.Errorf("%w: decompressed payload too large", ErrMalformedMessage)
+	}
+	return Message{Type: innerType, Version: msg.Version, Payload: payload}, nil
+}
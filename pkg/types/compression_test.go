@@ -0,0 +1,75 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomState is a game in progress: players spread over the field and
+// projectiles flying at full speed.
+func randomState(players int, projectiles int) GameState {
+	rng := rand.New(rand.NewSource(1))
+	state := GameState{Players: PlayerMap{}, Projectiles: ProjectileMap{}, TickNumber: 1000}
+	for i := range players {
+		id := ObjectID(i)
+		state.Players[id] = &Player{
+			ID:            id,
+			Position:      Vector{X: rng.Float64() * FieldMaxX, Y: rng.Float64() * FieldMaxY},
+			Speed:         Vector{X: (rng.Float64() - 0.5) * 85, Y: (rng.Float64() - 0.5) * 100},
+			IsAirborn:     rng.Intn(2) == 0,
+			ViewDirection: Direction(rng.Intn(4)),
+			HP:            uint32(1 + rng.Intn(5)),
+			LastInput:     uint32(rng.Intn(5000)),
+		}
+	}
+	for i := range projectiles {
+		id := ObjectID(i)
+		state.Projectiles[id] = &Projectile{
+			ID:       id,
+			Rune:     '•',
+			Position: Vector{X: rng.Float64() * FieldMaxX, Y: rng.Float64() * FieldMaxY},
+			Speed:    Vector{X: 50, Y: (rng.Float64() - 0.5) * 25},
+		}
+	}
+	return state
+}
+
+var compressionCases = []struct{ players, projectiles int }{
+	{4, 10},
+	{16, 200},
+	{16, 4000},
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	compressor := NewCompressor(C_DEFLATE)
+	for _, c := range compressionCases {
+		msg := randomState(c.players, c.projectiles).ToMessage()
+		decompressed, err := Decompress(compressor.Compress(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decompressed.Type != msg.Type || !bytes.Equal(decompressed.Payload, msg.Payload) {
+			t.Errorf("%d players, %d projectiles: decompressed message differs", c.players, c.projectiles)
+		}
+	}
+}
+
+// BenchmarkCompress reports the size of GameState.ToBytes next to the
+// compressed size of the same state.
+func BenchmarkCompress(b *testing.B) {
+	for _, c := range compressionCases {
+		b.Run(fmt.Sprintf("players=%d/projectiles=%d", c.players, c.projectiles), func(b *testing.B) {
+			msg := randomState(c.players, c.projectiles).ToMessage()
+			compressor := NewCompressor(C_DEFLATE)
+			compressed := Message{}
+			for b.Loop() {
+				compressed = compressor.Compress(msg)
+			}
+			b.ReportMetric(float64(len(msg.Payload)), "raw-bytes")
+			b.ReportMetric(float64(len(compressed.Payload)), "compressed-bytes")
+			b.ReportMetric(float64(len(compressed.Payload))/float64(len(msg.Payload))*100, "percent-of-raw")
+		})
+	}
+}
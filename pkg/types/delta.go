@@ -0,0 +1,129 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var ErrDeltaBaseMismatch = errors.New("delta does not apply to this state")
+
+type ObjectIDList []ObjectID
+
+func (ol ObjectIDList) ToBytes() []byte {
+	return MarshalWire(ol)
+}
+
+func (ol *ObjectIDList) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, ol)
+}
+
+// GameStateDelta describes the difference between a snapshot the client has
+// already acknowledged (BaseTick) and the current one (TickNumber).
+// Entities are compared by their wire representation, so changes that are
+// invisible to the client do not produce any traffic.
+type GameStateDelta struct {
+	BaseTick           GameTick      `json:"base_tick" wire:"1"`
+	TickNumber         GameTick      `json:"tick_number" wire:"2"`
+	ChangedPlayers     PlayerMap     `json:"changed_players" wire:"3"`
+	RemovedPlayers     ObjectIDList  `json:"removed_players" wire:"4"`
+	ChangedProjectiles ProjectileMap `json:"changed_projectiles" wire:"5"`
+	RemovedProjectiles ObjectIDList  `json:"removed_projectiles" wire:"6"`
+}
+
+func DiffGameState(base GameState, current GameState) GameStateDelta {
+	delta := GameStateDelta{
+		BaseTick:           base.TickNumber,
+		TickNumber:         current.TickNumber,
+		ChangedPlayers:     PlayerMap{},
+		RemovedPlayers:     ObjectIDList{},
+		ChangedProjectiles: ProjectileMap{},
+		RemovedProjectiles: ObjectIDList{},
+	}
+
+	for id, p := range current.Players {
+		baseP, ok := base.Players[id]
+		if !ok || !bytes.Equal(baseP.ToBytes(), p.ToBytes()) {
+			delta.ChangedPlayers[id] = p
+		}
+	}
+	for id := range base.Players {
+		if _, ok := current.Players[id]; !ok {
+			delta.RemovedPlayers = append(delta.RemovedPlayers, id)
+		}
+	}
+
+	for id, p := range current.Projectiles {
+		baseP, ok := base.Projectiles[id]
+		if !ok || !bytes.Equal(baseP.ToBytes(), p.ToBytes()) {
+			delta.ChangedProjectiles[id] = p
+		}
+	}
+	for id := range base.Projectiles {
+		if _, ok := current.Projectiles[id]; !ok {
+			delta.RemovedProjectiles = append(delta.RemovedProjectiles, id)
+		}
+	}
+	// Same states give the same bytes, whatever the map iteration order
+	slices.Sort(delta.RemovedPlayers)
+	slices.Sort(delta.RemovedProjectiles)
+	return delta
+}
+
+// ApplyTo builds the new state on top of the base one, which must be the
+// state of BaseTick. The base state is not modified, unchanged entities are
+// shared between the two.
+func (d GameStateDelta) ApplyTo(base GameState) (GameState, error) {
+	if base.TickNumber != d.BaseTick {
+		return GameState{}, fmt.Errorf("%w: base tick %d, state tick %d", ErrDeltaBaseMismatch, d.BaseTick, base.TickNumber)
+	}
+	gs := GameState{
+		Players:     make(PlayerMap, len(base.Players)),
+		Projectiles: make(ProjectileMap, len(base.Projectiles)),
+		MapObjects:  base.MapObjects,
+		TickNumber:  d.TickNumber,
+	}
+
+	for id, p := range base.Players {
+		gs.Players[id] = p
+	}
+	for _, id := range d.RemovedPlayers {
+		delete(gs.Players, id)
+	}
+	for id, p := range d.ChangedPlayers {
+		gs.Players[id] = p
+	}
+
+	for id, p := range base.Projectiles {
+		gs.Projectiles[id] = p
+	}
+	for _, id := range d.RemovedProjectiles {
+		delete(gs.Projectiles, id)
+	}
+	for id, p := range d.ChangedProjectiles {
+		gs.Projectiles[id] = p
+	}
+	return gs, nil
+}
+
+func (d GameStateDelta) ToBytes() []byte {
+	return MarshalWire(d)
+}
+
+func (d GameStateDelta) ToMessage() Message {
+	return NewMessage(MT_GAME_STATE_DELTA, d.ToBytes())
+}
+
+func GameStateDeltaFromBytes(reader io.Reader) (GameStateDelta, error) {
+	delta := GameStateDelta{
+		ChangedPlayers:     PlayerMap{},
+		ChangedProjectiles: ProjectileMap{},
+	}
+	err := UnmarshalWire(reader, &delta)
+	if err != nil {
+		return GameStateDelta{}, fmt.Errorf("decoding game state delta: %w", err)
+	}
+	return delta, nil
+}
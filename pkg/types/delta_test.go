@@ -0,0 +1,78 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := GameState{Players: makePlayers(3), Projectiles: makeProjectiles(5), TickNumber: 10}
+	baseBytes := base.ToBytes()
+
+	next := base.Clone()
+	next.TickNumber = 12
+	next.Players[0].Position.X += 1
+	delete(next.Players, 1)
+	next.Players[7] = &Player{ID: 7, Position: Vector{X: 3, Y: 4}, HP: 5}
+	next.Projectiles[2].Position.X += 2
+	delete(next.Projectiles, 0)
+	delete(next.Projectiles, 4)
+	next.Projectiles[9] = &Projectile{ID: 9, Rune: '•', Speed: Vector{X: -50}}
+
+	// Through the wire, as the client gets it
+	delta, err := GameStateDeltaFromBytes(bytes.NewReader(DiffGameState(base, next).ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(maps.Keys(delta.ChangedPlayers)); !slices.Equal(got, []ObjectID{0, 7}) {
+		t.Errorf("changed players %v, want [0 7]", got)
+	}
+	if !slices.Equal(delta.RemovedPlayers, ObjectIDList{1}) {
+		t.Errorf("removed players %v, want [1]", delta.RemovedPlayers)
+	}
+	if got := slices.Sorted(maps.Keys(delta.ChangedProjectiles)); !slices.Equal(got, []ObjectID{2, 9}) {
+		t.Errorf("changed projectiles %v, want [2 9]", got)
+	}
+	if !slices.Equal(delta.RemovedProjectiles, ObjectIDList{0, 4}) {
+		t.Errorf("removed projectiles %v, want [0 4]", delta.RemovedProjectiles)
+	}
+
+	applied, err := delta.ApplyTo(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(applied.ToBytes(), next.ToBytes()) {
+		t.Errorf("applied delta differs from the next state")
+	}
+	if !bytes.Equal(base.ToBytes(), baseBytes) {
+		t.Errorf("base state was modified")
+	}
+}
+
+func TestDeltaDeterministic(t *testing.T) {
+	base := GameState{Players: makePlayers(50), Projectiles: makeProjectiles(50), TickNumber: 1}
+	next := GameState{Players: makePlayers(10), Projectiles: makeProjectiles(10), TickNumber: 2}
+	want := DiffGameState(base, next).ToBytes()
+	for range 20 {
+		if !bytes.Equal(DiffGameState(base, next).ToBytes(), want) {
+			t.Fatal("same states gave different deltas")
+		}
+	}
+}
+
+func TestDeltaBaseTickMismatch(t *testing.T) {
+	base := GameState{Players: makePlayers(2), Projectiles: ProjectileMap{}, TickNumber: 10}
+	next := base.Clone()
+	next.TickNumber = 11
+	delta := DiffGameState(base, next)
+
+	other := base.Clone()
+	other.TickNumber = 9
+	_, err := delta.ApplyTo(other)
+	if !errors.Is(err, ErrDeltaBaseMismatch) {
+		t.Fatalf("got %v, want ErrDeltaBaseMismatch", err)
+	}
+}
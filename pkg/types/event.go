@@ -0,0 +1,155 @@
+package types
+
+import (
+	"fmt"
+	"io"
+)
+
+const MaxChatLength = 256
+
+type EventType byte
+
+const (
+	ET_JOIN  EventType = 0x01
+	ET_LEAVE EventType = 0x02
+	ET_HIT   EventType = 0x03
+	ET_DEATH EventType = 0x04
+	ET_CHAT  EventType = 0x05
+	// The player entered or left a trigger of the map
+	ET_TRIGGER_ENTER EventType = 0x06
+	ET_TRIGGER_EXIT  EventType = 0x07
+)
+
+func (et EventType) ToString() string {
+	switch et {
+	case ET_JOIN:
+		return "JOIN"
+	case ET_LEAVE:
+		return "LEAVE"
+	case ET_HIT:
+		return "HIT"
+	case ET_DEATH:
+		return "DEATH"
+	case ET_CHAT:
+		return "CHAT"
+	case ET_TRIGGER_ENTER:
+		return "TRIGGER_ENTER"
+	case ET_TRIGGER_EXIT:
+		return "TRIGGER_EXIT"
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", byte(et))
+}
+
+// GameEvent is something that happened during a tick. Events are sent
+// reliably after the snapshot of the tick, so the client doesn't need to
+// guess them from state changes.
+type GameEvent struct {
+	Type       EventType `json:"type" wire:"1"`
+	Tick       GameTick  `json:"tick" wire:"2"`
+	PlayerID   ObjectID  `json:"player_id" wire:"3"`
+	PlayerName string    `json:"player_name" wire:"4"`
+	// Projectile that hit the player, or index of the trigger in the map
+	// objects
+	ObjectID ObjectID `json:"object_id" wire:"5"`
+	// Chat message, or name of the trigger
+	Text string `json:"text" wire:"6"`
+	// Player whose projectile hit, for ET_HIT and ET_DEATH. The name is
+	// empty if the shooter already left.
+	ShooterID   ObjectID `json:"shooter_id" wire:"7"`
+	ShooterName string   `json:"shooter_name" wire:"8"`
+}
+
+func (e GameEvent) ToString() string {
+	switch e.Type {
+	case ET_JOIN:
+		return fmt.Sprintf("%s joined", e.PlayerName)
+	case ET_LEAVE:
+		return fmt.Sprintf("%s left", e.PlayerName)
+	case ET_HIT:
+		if e.ShooterName == "" {
+			return fmt.Sprintf("%s was hit", e.PlayerName)
+		}
+		return fmt.Sprintf("%s was hit by %s", e.PlayerName, e.ShooterName)
+	case ET_DEATH:
+		if e.ShooterName == "" {
+			return fmt.Sprintf("%s died", e.PlayerName)
+		}
+		return fmt.Sprintf("%s was killed by %s", e.PlayerName, e.ShooterName)
+	case ET_CHAT:
+		return fmt.Sprintf("%s: %s", e.PlayerName, e.Text)
+	case ET_TRIGGER_ENTER, ET_TRIGGER_EXIT:
+		verb := "entered"
+		if e.Type == ET_TRIGGER_EXIT {
+			verb = "left"
+		}
+		if e.Text == "" {
+			return fmt.Sprintf("%s %s trigger %d", e.PlayerName, verb, e.ObjectID)
+		}
+		return fmt.Sprintf("%s %s %s", e.PlayerName, verb, e.Text)
+	}
+	return e.Type.ToString()
+}
+
+func (e *GameEvent) validateWire() error {
+	if e.Type < ET_JOIN || e.Type > ET_TRIGGER_EXIT {
+		return fmt.Errorf("%w: event type %s", ErrMalformedMessage, e.Type.ToString())
+	}
+	return nil
+}
+
+type GameEvents []GameEvent
+
+func (events GameEvents) ToBytes() []byte {
+	return MarshalWire(events)
+}
+
+func (events *GameEvents) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, events)
+}
+
+func (events GameEvents) ToMessage() Message {
+	return NewMessage(MT_EVENTS, events.ToBytes())
+}
+
+func GameEventsFromBytes(reader io.Reader) (GameEvents, error) {
+	events := GameEvents{}
+	err := events.FillFromBytes(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decoding game events: %w", err)
+	}
+	return events, nil
+}
+
+// ChatMessage is sent by the client, the server forwards it to everyone as
+// an ET_CHAT event.
+type ChatMessage struct {
+	Text string `json:"text" wire:"1"`
+}
+
+func (cm ChatMessage) ToBytes() []byte {
+	return MarshalWire(cm)
+}
+
+func (cm *ChatMessage) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, cm)
+}
+
+func (cm *ChatMessage) validateWire() error {
+	if len(cm.Text) == 0 || len(cm.Text) > MaxChatLength {
+		return fmt.Errorf("%w: chat message of %d bytes", ErrMalformedMessage, len(cm.Text))
+	}
+	return nil
+}
+
+func (cm ChatMessage) ToMessage() Message {
+	return NewMessage(MT_CHAT, cm.ToBytes())
+}
+
+func ChatMessageFromBytes(reader io.Reader) (ChatMessage, error) {
+	chat := ChatMessage{}
+	err := chat.FillFromBytes(reader)
+	if err != nil {
+		return ChatMessage{}, fmt.Errorf("decoding chat message: %w", err)
+	}
+	return chat, nil
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+)
+
+// checkReencode fails if a successful decode doesn't encode back to the
+// bytes it was read from. Bytes after the message are not read.
+func checkReencode(t *testing.T, data []byte, reader *bytes.Reader, encoded []byte) {
+	t.Helper()
+	read := data[:len(data)-reader.Len()]
+	if !bytes.Equal(encoded, read) {
+		t.Fatalf("re-encoded %x, decoded from %x", encoded, read)
+	}
+}
+
+func FuzzGameStateFromBytes(f *testing.F) {
+	f.Add(GameState{Players: PlayerMap{}, Projectiles: ProjectileMap{}}.ToBytes())
+	f.Add(GameState{Players: makePlayers(3), Projectiles: makeProjectiles(2), TickNumber: 42}.ToBytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		state, err := GameStateFromBytes(reader)
+		if err != nil {
+			return
+		}
+		checkReencode(t, data, reader, state.ToBytes())
+	})
+}
+
+func FuzzInitializationDataFromBytes(f *testing.F) {
+	f.Add(InitializationData{}.ToBytes())
+	f.Add(InitializationData{
+		PlayerID:  7,
+		FieldMaxX: FieldMaxX,
+		FieldMaxY: FieldMaxY,
+		MapObjects: []MapObject{
+			{Position: Vector{X: 5, Y: 1}, CollisionArea: CollisionArea{X: 14, Y: 2}, IsVisible: true, Mask: CL_ALL},
+			{Position: Vector{X: -1, Y: 0.5}, CollisionArea: CollisionArea{X: 3, Y: 3}, Mask: CL_PLAYER, IsTrigger: true, Action: TA_KILL, Name: "lava"},
+		},
+		Compression: C_DEFLATE,
+	}.ToBytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		initData, err := InitializationDataFromBytes(reader)
+		if err != nil {
+			return
+		}
+		checkReencode(t, data, reader, initData.ToBytes())
+	})
+}
+
+func FuzzInputCommandFromBytes(f *testing.F) {
+	f.Add(InputCommand{Aim: D_RIGHT}.ToBytes())
+	f.Add(InputCommand{Sequence: 12, Tick: 300, Actions: IA_LEFT | IA_RUN | IA_SHOOT, Aim: D_UP}.ToBytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		input, err := InputCommandFromBytes(reader)
+		if err != nil {
+			return
+		}
+		checkReencode(t, data, reader, input.ToBytes())
+	})
+}
@@ -0,0 +1,102 @@
+package types
+
+import (
+	"fmt"
+	"io"
+)
+
+// BuildID identifies the binary that is talking to the other side. It is
+// only informational, set it with
+// -ldflags "-X github.com/Doki-Doki-IT-Literature-Club/demo-game/pkg/types.BuildID=..."
+var BuildID = "dev"
+
+const MaxPlayerNameLength = 32
+
+// ClientHello is the first message sent by the client. Its leading fields
+// must not change between protocol versions, so that the server can always
+// tell an outdated client why it was rejected. New fields go to the end.
+type ClientHello struct {
+	ProtocolVersion byte          `json:"protocol_version" wire:"1"`
+	BuildID         string        `json:"build_id" wire:"2"`
+	PlayerName      string        `json:"player_name" wire:"3"`
+	Compressions    []Compression `json:"compressions" wire:"4"`
+}
+
+func (ch ClientHello) ToBytes() []byte {
+	return MarshalWire(ch)
+}
+
+func (ch *ClientHello) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, ch)
+}
+
+func (ch ClientHello) ToMessage() Message {
+	return NewMessage(MT_CLIENT_HELLO, ch.ToBytes())
+}
+
+func ClientHelloFromBytes(reader io.Reader) (ClientHello, error) {
+	hello := ClientHello{}
+	err := hello.FillFromBytes(reader)
+	if err != nil {
+		return ClientHello{}, fmt.Errorf("decoding client hello: %w", err)
+	}
+	return hello, nil
+}
+
+type RejectReason byte
+
+const (
+	RR_VERSION_MISMATCH RejectReason = 0x01
+	RR_SERVER_FULL      RejectReason = 0x02
+	RR_BANNED           RejectReason = 0x03
+	RR_BAD_HELLO        RejectReason = 0x04
+)
+
+func (rr RejectReason) ToString() string {
+	switch rr {
+	case RR_VERSION_MISMATCH:
+		return "version mismatch"
+	case RR_SERVER_FULL:
+		return "server full"
+	case RR_BANNED:
+		return "banned"
+	case RR_BAD_HELLO:
+		return "bad hello"
+	}
+	return fmt.Sprintf("unknown reason 0x%02x", byte(rr))
+}
+
+// Rejection is sent by the server instead of InitializationData when the
+// client can't join. Same as ClientHello, its layout is version independent.
+type Rejection struct {
+	Reason  RejectReason `json:"reason" wire:"1"`
+	Details string       `json:"details" wire:"2"`
+}
+
+func (r Rejection) ToString() string {
+	if r.Details == "" {
+		return r.Reason.ToString()
+	}
+	return fmt.Sprintf("%s: %s", r.Reason.ToString(), r.Details)
+}
+
+func (r Rejection) ToBytes() []byte {
+	return MarshalWire(r)
+}
+
+func (r *Rejection) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, r)
+}
+
+func (r Rejection) ToMessage() Message {
+	return NewMessage(MT_REJECT, r.ToBytes())
+}
+
+func RejectionFromBytes(reader io.Reader) (Rejection, error) {
+	rejection := Rejection{}
+	err := rejection.FillFromBytes(reader)
+	if err != nil {
+		return Rejection{}, fmt.Errorf("decoding rejection: %w", err)
+	}
+	return rejection, nil
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"fmt"
+	"io"
+)
+
+// InputAction is a bitmask of actions the player is holding.
+type InputAction byte
+
+const (
+	IA_LEFT InputAction = 1 << iota
+	IA_RIGHT
+	IA_UP
+	IA_DOWN
+	IA_RUN
+	IA_SHOOT
+)
+
+func (ia InputAction) Has(action InputAction) bool {
+	return ia&action != 0
+}
+
+// InputCommand is the input state of a player: what is held and where the
+// player aims. It stays in effect on the server until the next one arrives,
+// so the client only sends it when something changes.
+//
+// Sequence grows by one with every input sent by the client, Tick is the
+// server tick the input is meant for. The server echoes the sequence of the
+// last input it applied in every snapshot, see Player.LastInput.
+type InputCommand struct {
+	Sequence uint32      `json:"sequence" wire:"1"`
+	Tick     GameTick    `json:"tick" wire:"2"`
+	Actions  InputAction `json:"actions" wire:"3"`
+	Aim      Direction   `json:"aim" wire:"4"`
+}
+
+func (ic InputCommand) ToBytes() []byte {
+	return MarshalWire(ic)
+}
+
+func (ic *InputCommand) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, ic)
+}
+
+func (ic *InputCommand) validateWire() error {
+	if !ic.Aim.IsValid() {
+		return fmt.Errorf("%w: aim direction %d", ErrMalformedMessage, ic.Aim)
+	}
+	return nil
+}
+
+func (ic InputCommand) ToMessage() Message {
+	return NewMessage(MT_COMMAND, ic.ToBytes())
+}
+
+func InputCommandFromBytes(reader io.Reader) (InputCommand, error) {
+	input := InputCommand{}
+	err := input.FillFromBytes(reader)
+	if err != nil {
+		return InputCommand{}, fmt.Errorf("decoding input command: %w", err)
+	}
+	return input, nil
+}
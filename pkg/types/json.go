@@ -0,0 +1,118 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Messages can also be written as JSON, one object per line:
+//
+//	{"type":"CLIENT_HELLO","version":13,"payload":{"protocol_version":13,"player_name":"nc"}}
+//
+// This is meant for debugging only. The payload goes through the same wire
+// decoding and validation as binary messages, so both forms mean exactly
+// the same. A missing version means ProtocolVersion.
+
+type JSONMessage struct {
+	Type    string          `json:"type"`
+	Version byte            `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// payloadValue returns a pointer to the value carried by messages of the
+// type, nil for messages without payload.
+func payloadValue(messageType MessageType) (any, error) {
+	switch messageType {
+	case MT_INITIALIZATION_DATA:
+		return &InitializationData{}, nil
+	case MT_GAME_STATE:
+		return &GameState{Players: PlayerMap{}, Projectiles: ProjectileMap{}}, nil
+	case MT_COMMAND:
+		return &InputCommand{}, nil
+	case MT_GAME_STATE_DELTA:
+		return &GameStateDelta{ChangedPlayers: PlayerMap{}, ChangedProjectiles: ProjectileMap{}}, nil
+	case MT_ACK:
+		tick := GameTick(0)
+		return &tick, nil
+	case MT_FULL_STATE_REQUEST:
+		return nil, nil
+	case MT_CLIENT_HELLO:
+		return &ClientHello{}, nil
+	case MT_REJECT:
+		return &Rejection{}, nil
+	case MT_EVENTS:
+		return &GameEvents{}, nil
+	case MT_CHAT:
+		return &ChatMessage{}, nil
+	}
+	return nil, fmt.Errorf("%s has no JSON form", messageType.ToString())
+}
+
+func messageTypeFromString(s string) (MessageType, bool) {
+	for mt := MT_INITIALIZATION_DATA; mt <= MT_CHAT; mt++ {
+		if mt.ToString() == s {
+			return mt, true
+		}
+	}
+	return 0, false
+}
+
+func (m Message) ToJSON() ([]byte, error) {
+	value, err := payloadValue(m.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	jm := JSONMessage{Type: m.Type.ToString(), Version: m.Version}
+	if value != nil {
+		err = UnmarshalWire(bytes.NewReader(m.Payload), value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", m.Type.ToString(), err)
+		}
+		jm.Payload, err = json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(jm)
+}
+
+func MessageFromJSON(data []byte) (msg Message, err error) {
+	// MarshalWire panics on values it can't encode, such as too many
+	// entities, those can only come from a bad message here
+	defer func() {
+		if r := recover(); r != nil {
+			msg, err = Message{}, fmt.Errorf("%w: %v", ErrMalformedMessage, r)
+		}
+	}()
+
+	jm := JSONMessage{}
+	err = json.Unmarshal(data, &jm)
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	messageType, ok := messageTypeFromString(jm.Type)
+	if !ok {
+		return Message{}, fmt.Errorf("%w: unknown message type %q", ErrMalformedMessage, jm.Type)
+	}
+	value, err := payloadValue(messageType)
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+
+	msg = NewMessage(messageType, nil)
+	if jm.Version != 0 {
+		msg.Version = jm.Version
+	}
+	if value != nil && len(jm.Payload) > 0 {
+		err = json.Unmarshal(jm.Payload, value)
+		if err != nil {
+			return Message{}, fmt.Errorf("%w: %s payload: %v", ErrMalformedMessage, jm.Type, err)
+		}
+	}
+	if value != nil {
+		msg.Payload = MarshalWire(value)
+	}
+	return msg, nil
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ProtocolVersion is sent in every frame header. Bump it on any
+// incompatible change of the wire format.
+const ProtocolVersion byte = 14
+
+// Frame layout: [type: 1][version: 1][payload length: 4][payload]
+const frameHeaderSize = 6
+
+// MaxPayloadSize protects readers from allocating huge buffers because of a
+// corrupted or malicious length field.
+const MaxPayloadSize = 1 << 22
+
+// ErrMalformedMessage is returned by decoders for input that was read
+// completely but doesn't make sense.
+var ErrMalformedMessage = errors.New("malformed message")
+
+type MessageType byte
+
+const (
+	MT_INITIALIZATION_DATA MessageType = 0x01
+	MT_GAME_STATE          MessageType = 0x02
+	MT_COMMAND             MessageType = 0x03
+	MT_GAME_STATE_DELTA    MessageType = 0x04
+	MT_ACK                 MessageType = 0x05
+	MT_FULL_STATE_REQUEST  MessageType = 0x06
+	MT_CLIENT_HELLO        MessageType = 0x07
+	MT_REJECT              MessageType = 0x08
+	MT_COMPRESSED          MessageType = 0x09
+	MT_EVENTS              MessageType = 0x0A
+	MT_CHAT                MessageType = 0x0B
+)
+
+func (mt MessageType) ToString() string {
+	switch mt {
+	case MT_INITIALIZATION_DATA:
+		return "INITIALIZATION_DATA"
+	case MT_GAME_STATE:
+		return "GAME_STATE"
+	case MT_COMMAND:
+		return "COMMAND"
+	case MT_GAME_STATE_DELTA:
+		return "GAME_STATE_DELTA"
+	case MT_ACK:
+		return "ACK"
+	case MT_FULL_STATE_REQUEST:
+		return "FULL_STATE_REQUEST"
+	case MT_CLIENT_HELLO:
+		return "CLIENT_HELLO"
+	case MT_REJECT:
+		return "REJECT"
+	case MT_COMPRESSED:
+		return "COMPRESSED"
+	case MT_EVENTS:
+		return "EVENTS"
+	case MT_CHAT:
+		return "CHAT"
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", byte(mt))
+}
+
+type Message struct {
+	Type    MessageType
+	Version byte
+	Payload []byte
+}
+
+func NewMessage(messageType MessageType, payload []byte) Message {
+	return Message{Type: messageType, Version: ProtocolVersion, Payload: payload}
+}
+
+func (m Message) ToBytes() []byte {
+	res := make([]byte, frameHeaderSize, frameHeaderSize+len(m.Payload))
+	res[0] = byte(m.Type)
+	res[1] = m.Version
+	binary.BigEndian.PutUint32(res[2:6], uint32(len(m.Payload)))
+	return append(res, m.Payload...)
+}
+
+// ReadMessage reads exactly one frame from the reader. It never reads past
+// the end of the frame, so it can be called in a loop on a stream.
+func ReadMessage(reader io.Reader) (Message, error) {
+	header := [frameHeaderSize]byte{}
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		return Message{}, err
+	}
+
+	payloadLen := binary.BigEndian.Uint32(header[2:6])
+	if payloadLen > MaxPayloadSize {
+		return Message{}, fmt.Errorf("%w: frame payload too large: %d bytes", ErrMalformedMessage, payloadLen)
+	}
+
+	msg := Message{
+		Type:    MessageType(header[0]),
+		Version: header[1],
+		Payload: make([]byte, payloadLen),
+	}
+	_, err = io.ReadFull(reader, msg.Payload)
+	if err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
@@ -7,22 +7,11 @@ import (
 	"io"
 	"math"
 	"os"
+	"slices"
 )
 
 type ObjectID uint32
 
-type Command byte
-
-const (
-	UP        = 0x01
-	DOWN      = 0x02
-	LEFT      = 0x03
-	LEFT_RUN  = 0x04
-	RIGHT     = 0x05
-	RIGHT_RUN = 0x06
-	SHOOT     = 0x07
-)
-
 type Direction uint32
 
 const (
@@ -32,6 +21,10 @@ const (
 	D_RIGHT
 )
 
+func (d Direction) IsValid() bool {
+	return d <= D_RIGHT
+}
+
 func (d Direction) AsVector() Vector {
 	switch d {
 	case D_UP:
@@ -63,9 +56,13 @@ func (d Direction) AsRune() rune {
 const FieldMaxX = 500
 const FieldMaxY = 500
 
+// MaxFieldSize limits the field size accepted from the server, client
+// allocates the whole field for rendering.
+const MaxFieldSize = 4096
+
 type Vector struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
+	X float64 `json:"x" wire:"1"`
+	Y float64 `json:"y" wire:"2"`
 }
 
 func (v Vector) Add(other Vector) Vector {
@@ -96,6 +93,19 @@ func (v Vector) ToString() string {
 	return fmt.Sprintf("{X: %.2f | Y: %.2f}", v.X, v.Y)
 }
 
+// FixedPointScale is the number of wire units in one cell. Positions and
+// speeds are sent as signed fixed point numbers with 1/FixedPointScale
+// precision, which is the same on server and client.
+const FixedPointScale = 1024
+
+func toFixedPoint(f float64) int32 {
+	return int32(math.Round(f * FixedPointScale))
+}
+
+func fromFixedPoint(i int32) float64 {
+	return float64(i) / FixedPointScale
+}
+
 type CollisionArea Vector
 
 func (ca CollisionArea) ToCollisionBox(position Vector) CollisionBox {
@@ -116,6 +126,7 @@ type MovableObject interface {
 	CollidableObject
 
 	GetID() ObjectID
+	GetCollisionLayer() CollisionLayer
 	GetSpeed() Vector
 	SetSpeed(Vector)
 
@@ -123,13 +134,30 @@ type MovableObject interface {
 }
 
 type Player struct {
-	ID            ObjectID
-	Position      Vector
-	CollisionArea CollisionArea
-	Speed         Vector
-	IsAirborn     bool
-	ViewDirection Direction
-	HP            uint32
+	ID            ObjectID      `json:"id" wire:"1"`
+	Name          string        `json:"-"`
+	Position      Vector        `json:"position" wire:"3,fixed"`
+	CollisionArea CollisionArea `json:"-"`
+	Speed         Vector        `json:"speed" wire:"4,fixed"`
+	IsAirborn     bool          `json:"is_airborn" wire:"7"`
+	ViewDirection Direction     `json:"view_direction" wire:"2"`
+	HP            uint32        `json:"hp" wire:"5"`
+	// LastInput is the sequence of the last input applied to the player,
+	// the client uses it to tell which of its inputs are still in flight
+	LastInput uint32 `json:"last_input" wire:"6"`
+	// Last input applied to the player, server side only
+	Input InputCommand `json:"-"`
+	// Owner of the last projectile that hit the player, credited with the
+	// kill, and the tick of the hit, zero if never. Server side only.
+	LastHitBy   ObjectID `json:"-"`
+	LastHitTick GameTick `json:"-"`
+	// Last tick the player stood on something and the tick UP was pressed
+	// without jumping yet, zero if never. Server side only.
+	GroundedTick    GameTick `json:"-"`
+	JumpPressedTick GameTick `json:"-"`
+	// Tick of the last shot, a player shoots at most once per tick. Server
+	// side only.
+	LastShotTick GameTick `json:"-"`
 }
 
 func (p *Player) ToString() string {
@@ -164,6 +192,10 @@ func (p Player) GetCollisionArea() CollisionArea {
 	return p.CollisionArea
 }
 
+func (p Player) GetCollisionLayer() CollisionLayer {
+	return CL_PLAYER
+}
+
 func (p Player) GetCollisionBox() CollisionBox {
 	return p.CollisionArea.ToCollisionBox(p.Position)
 }
@@ -171,42 +203,40 @@ func (p Player) GetCollisionBox() CollisionBox {
 func (p *Player) OnCollision(co CollidableObject) {
 	switch co.(type) {
 	case *Projectile:
-		p.HP -= 1
+		// Several hits may land in the tick the player dies
+		if p.HP > 0 {
+			p.HP--
+		}
 	default:
 		return
 	}
 }
 
 func (p Player) ToBytes() []byte {
-	pb := [20]byte{}
-	binary.BigEndian.PutUint32(pb[:4], uint32(p.ID))
-	binary.BigEndian.PutUint32(pb[4:8], uint32(p.ViewDirection))
-	binary.BigEndian.PutUint32(pb[8:12], uint32(math.Round(p.Position.X)))
-	binary.BigEndian.PutUint32(pb[12:16], uint32(math.Round(p.Position.Y)))
-	binary.BigEndian.PutUint32(pb[16:], p.HP)
-	return pb[:]
-}
-
-func (p *Player) FillFromBytes(reader io.Reader) {
-	data := make([]byte, 20)
-	_, err := reader.Read(data)
-	if err != nil {
-		panic(err)
+	return MarshalWire(p)
+}
+
+func (p *Player) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, p)
+}
+
+func (p *Player) validateWire() error {
+	if !p.ViewDirection.IsValid() {
+		return fmt.Errorf("%w: player %d has view direction %d", ErrMalformedMessage, p.ID, p.ViewDirection)
 	}
-	p.ID = ObjectID(binary.BigEndian.Uint32(data[:4]))
-	p.ViewDirection = Direction(binary.BigEndian.Uint32(data[4:8]))
-	X := binary.BigEndian.Uint32(data[8:12])
-	Y := binary.BigEndian.Uint32(data[12:16])
-	p.Position = Vector{float64(X), float64(Y)}
-	p.HP = binary.BigEndian.Uint32(data[16:20])
+	return nil
 }
 
 type Projectile struct {
-	ID            ObjectID
-	Rune          rune
-	Position      Vector
-	Speed         Vector
-	CollisionArea CollisionArea
+	ID            ObjectID      `json:"id" wire:"1"`
+	Rune          rune          `json:"rune" wire:"2"`
+	Position      Vector        `json:"position" wire:"3,fixed"`
+	Speed         Vector        `json:"speed" wire:"4,fixed"`
+	CollisionArea CollisionArea `json:"-"`
+	// Player that shot the projectile and the tick it happened, server
+	// side only
+	OwnerID   ObjectID `json:"-"`
+	SpawnTick GameTick `json:"-"`
 }
 
 func (p Projectile) GetID() ObjectID {
@@ -233,6 +263,10 @@ func (p Projectile) GetCollisionArea() CollisionArea {
 	return p.CollisionArea
 }
 
+func (p Projectile) GetCollisionLayer() CollisionLayer {
+	return CL_PROJECTILE
+}
+
 func (p Projectile) GetCollisionBox() CollisionBox {
 	return p.CollisionArea.ToCollisionBox(p.Position)
 }
@@ -250,152 +284,155 @@ func (p Projectile) ToString() string {
 	)
 }
 func (p Projectile) ToBytes() []byte {
-	pb := [16]byte{}
-	binary.BigEndian.PutUint32(pb[:4], uint32(p.ID))
-	binary.BigEndian.PutUint32(pb[4:8], uint32(p.Rune))
-	binary.BigEndian.PutUint32(pb[8:12], uint32(p.Position.X))
-	binary.BigEndian.PutUint32(pb[12:], uint32(p.Position.Y))
-	return pb[:]
+	return MarshalWire(p)
 }
 
-func (p *Projectile) FillFromBytes(reader io.Reader) {
-	data := make([]byte, 16, 16)
-	_, err := reader.Read(data)
-	if err != nil {
-		panic(err)
-	}
-	p.ID = ObjectID(binary.BigEndian.Uint32(data[:4]))
-	p.Rune = rune(binary.BigEndian.Uint32(data[4:8]))
-	X := binary.BigEndian.Uint32(data[8:12])
-	Y := binary.BigEndian.Uint32(data[12:16])
-	p.Position = Vector{float64(X), float64(Y)}
+func (p *Projectile) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, p)
 }
 
-type PlayerMap map[ObjectID]*Player
+// MaxEntityCount is the upper bound for the number of entities of one kind
+// in a single message. Decoders reject anything above it instead of trying
+// to read a garbage count, encoders panic, so the simulation never holds
+// more.
+const MaxEntityCount = 1 << 16
 
-func (pm PlayerMap) ToBytes() []byte {
-	res := []byte{byte(len(pm))}
-	for _, p := range pm {
-		res = append(res, p.ToBytes()[:]...)
+func entityCountToBytes(count int) []byte {
+	if count > MaxEntityCount {
+		panic(fmt.Errorf("too many entities to encode: %d", count))
 	}
-	return res
+	b := [4]byte{}
+	binary.BigEndian.PutUint32(b[:], uint32(count))
+	return b[:]
 }
 
-func (pm PlayerMap) FillFromBytes(reader io.Reader) {
-	playerNumberBuff := make([]byte, 1, 1)
-	_, err := reader.Read(playerNumberBuff)
+func entityCountFromBytes(reader io.Reader) (int, error) {
+	data := [4]byte{}
+	_, err := io.ReadFull(reader, data[:])
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	playerNumber := int(playerNumberBuff[0])
-	for range playerNumber {
-		player := Player{}
-		player.FillFromBytes(reader)
-
-		pm[player.ID] = &player
+	count := binary.BigEndian.Uint32(data[:])
+	if count > MaxEntityCount {
+		return 0, fmt.Errorf("%w: entity count %d exceeds limit %d", ErrMalformedMessage, count, MaxEntityCount)
 	}
+	return int(count), nil
+}
+
+type PlayerMap map[ObjectID]*Player
+
+func (pm PlayerMap) ToBytes() []byte {
+	return MarshalWire(pm)
+}
+
+func (pm PlayerMap) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, &pm)
 }
 
 type ProjectileMap map[ObjectID]*Projectile
 
 func (pm ProjectileMap) ToBytes() []byte {
-	res := []byte{byte(len(pm))}
-	for _, p := range pm {
-		res = append(res, p.ToBytes()[:]...)
-	}
-	return res
+	return MarshalWire(pm)
 }
 
-func (pm ProjectileMap) FillFromBytes(reader io.Reader) {
-	projectileNuberBuff := make([]byte, 1, 1)
-	_, err := reader.Read(projectileNuberBuff)
-	if err != nil {
-		panic(err)
-	}
-	projectileNumber := int(projectileNuberBuff[0])
-	for range projectileNumber {
-		projectile := Projectile{}
-		projectile.FillFromBytes(reader)
-
-		pm[projectile.ID] = &projectile
-	}
+func (pm ProjectileMap) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, &pm)
 }
 
 type InitializationData struct {
-	PlayerID ObjectID
+	PlayerID   ObjectID    `json:"player_id" wire:"1"`
+	FieldMaxX  uint32      `json:"field_max_x" wire:"2"`
+	FieldMaxY  uint32      `json:"field_max_y" wire:"3"`
+	MapObjects []MapObject `json:"map_objects" wire:"4"`
+	// Compression of the frames sent by the server, one of those the client
+	// listed in its hello
+	Compression Compression `json:"compression" wire:"5"`
 }
 
 func (initData InitializationData) ToBytes() []byte {
-	res := [4]byte{}
-	binary.BigEndian.PutUint32(res[:4], uint32(initData.PlayerID))
-	return res[:]
+	return MarshalWire(initData)
 }
 
-func (initData *InitializationData) FillFromBytes(reader io.Reader) {
-	playerIDBuff := [4]byte{}
-	_, err := reader.Read(playerIDBuff[:])
-	if err != nil {
-		panic(err)
+func (initData *InitializationData) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, initData)
+}
+
+func (initData *InitializationData) validateWire() error {
+	if initData.FieldMaxX > MaxFieldSize || initData.FieldMaxY > MaxFieldSize {
+		return fmt.Errorf("%w: field size %dx%d exceeds limit %d", ErrMalformedMessage, initData.FieldMaxX, initData.FieldMaxY, MaxFieldSize)
+	}
+	if initData.Compression != C_NONE && !slices.Contains(SupportedCompressions, initData.Compression) {
+		return fmt.Errorf("%w: %s", ErrMalformedMessage, initData.Compression.ToString())
 	}
+	return nil
+}
 
-	playerID := ObjectID(binary.BigEndian.Uint32(playerIDBuff[:4]))
-	initData.PlayerID = playerID
+func (initData InitializationData) ToMessage() Message {
+	return NewMessage(MT_INITIALIZATION_DATA, initData.ToBytes())
 }
 
-func InitializationDataFromBytes(reader io.Reader) InitializationData {
+func InitializationDataFromBytes(reader io.Reader) (InitializationData, error) {
 	initializationData := InitializationData{}
-	initializationData.FillFromBytes(reader)
-	return initializationData
+	err := initializationData.FillFromBytes(reader)
+	if err != nil {
+		return InitializationData{}, fmt.Errorf("decoding initialization data: %w", err)
+	}
+	return initializationData, nil
 }
 
 type GameTick uint64
 
 func (gt GameTick) ToBytes() []byte {
-	b := [8]byte{}
-	binary.BigEndian.PutUint64(b[:], uint64(gt))
-	return b[:]
+	return MarshalWire(gt)
 }
 
-func (gt *GameTick) FillFromBytes(reader io.Reader) {
-	data := make([]byte, 8)
-	_, err := reader.Read(data)
-	if err != nil {
-		panic(err)
-	}
-	*gt = GameTick(binary.BigEndian.Uint64(data[:8]))
+func (gt *GameTick) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, gt)
 }
 
 type GameState struct {
-	Players     PlayerMap
-	Projectiles ProjectileMap
-	MapObjects  []MapObject
-	TickNumber  GameTick
+	Players     PlayerMap     `json:"players" wire:"1"`
+	Projectiles ProjectileMap `json:"projectiles" wire:"2"`
+	MapObjects  []MapObject   `json:"-"`
+	TickNumber  GameTick      `json:"tick_number" wire:"3"`
+}
+
+// Clone returns a copy of the state that does not share players and
+// projectiles with the original. Map objects are static and stay shared.
+func (gs GameState) Clone() GameState {
+	clone := GameState{
+		Players:     make(PlayerMap, len(gs.Players)),
+		Projectiles: make(ProjectileMap, len(gs.Projectiles)),
+		MapObjects:  gs.MapObjects,
+		TickNumber:  gs.TickNumber,
+	}
+	for id, p := range gs.Players {
+		player := *p
+		clone.Players[id] = &player
+	}
+	for id, p := range gs.Projectiles {
+		projectile := *p
+		clone.Projectiles[id] = &projectile
+	}
+	return clone
 }
 
 func (gs GameState) ToBytes() []byte {
-	res := []byte{}
-
-	res = append(res, gs.Players.ToBytes()...)
-	res = append(res, gs.Projectiles.ToBytes()...)
-	res = append(res, gs.TickNumber.ToBytes()...)
-	return res
+	return MarshalWire(gs)
 }
 
-func GameStateFromBytes(reader io.Reader) GameState {
-	playerMap := PlayerMap{}
-	playerMap.FillFromBytes(reader)
-
-	projectileMap := ProjectileMap{}
-	projectileMap.FillFromBytes(reader)
-
-	tickNumber := GameTick(0)
-	tickNumber.FillFromBytes(reader)
-
-	gameState := GameState{Players: playerMap, Projectiles: projectileMap, TickNumber: tickNumber}
-	return gameState
+func (gs GameState) ToMessage() Message {
+	return NewMessage(MT_GAME_STATE, gs.ToBytes())
 }
 
-// TODO: Map objects should be dynamic and passed from server to client on init
+func GameStateFromBytes(reader io.Reader) (GameState, error) {
+	gameState := GameState{Players: PlayerMap{}, Projectiles: ProjectileMap{}}
+	err := UnmarshalWire(reader, &gameState)
+	if err != nil {
+		return GameState{}, fmt.Errorf("decoding game state: %w", err)
+	}
+	return gameState, nil
+}
 
 type CollisionBox struct {
 	BottomLeft Vector
@@ -432,10 +469,71 @@ func (cb CollisionBox) ToString() string {
 	return fmt.Sprintf("[%s, %s]", cb.BottomLeft.ToString(), cb.TopRight.ToString())
 }
 
+// CollisionLayer is a bitmask of kinds of moving objects.
+type CollisionLayer byte
+
+const (
+	CL_PLAYER CollisionLayer = 1 << iota
+	CL_PROJECTILE
+
+	CL_ALL = CL_PLAYER | CL_PROJECTILE
+)
+
+func (cl CollisionLayer) Has(layer CollisionLayer) bool {
+	return cl&layer != 0
+}
+
+// TriggerAction is what a trigger does to objects entering it.
+type TriggerAction byte
+
+const (
+	TA_NONE TriggerAction = 0x00
+	// Kills players and removes projectiles
+	TA_KILL TriggerAction = 0x01
+)
+
+func (ta TriggerAction) IsValid() bool {
+	return ta <= TA_KILL
+}
+
+// MapObject is a wall by default. Mask limits the layers it applies to, a
+// wall with an empty mask is a decoration everything passes through. A
+// trigger doesn't block anything, the objects of its layers entering and
+// leaving it fire ET_TRIGGER_ENTER and ET_TRIGGER_EXIT (players only) and
+// the action.
 type MapObject struct {
-	Position      Vector        `json:"position"`
-	CollisionArea CollisionArea `json:"collision_area"`
-	IsVisible     bool          `json:"is_visible"`
+	Position      Vector        `json:"position" wire:"1"`
+	CollisionArea CollisionArea `json:"collision_area" wire:"2"`
+	IsVisible     bool          `json:"is_visible" wire:"3"`
+	// All layers if missing in JSON
+	Mask      CollisionLayer `json:"mask" wire:"4"`
+	IsTrigger bool           `json:"is_trigger,omitempty" wire:"5"`
+	Action    TriggerAction  `json:"action,omitempty" wire:"6"`
+	// Shown in trigger events, e.g. the name of a capture area
+	Name string `json:"name,omitempty" wire:"7"`
+}
+
+// UnmarshalJSON makes objects without a mask solid for everything, like
+// all walls of maps made before layers.
+func (mo *MapObject) UnmarshalJSON(data []byte) error {
+	type plainMapObject MapObject
+	plain := plainMapObject{Mask: CL_ALL}
+	err := json.Unmarshal(data, &plain)
+	if err != nil {
+		return err
+	}
+	*mo = MapObject(plain)
+	return nil
+}
+
+// Blocks tells whether objects of the layer can't move through.
+func (mo MapObject) Blocks(layer CollisionLayer) bool {
+	return !mo.IsTrigger && mo.Mask.Has(layer)
+}
+
+// Triggers tells whether objects of the layer fire the trigger.
+func (mo MapObject) Triggers(layer CollisionLayer) bool {
+	return mo.IsTrigger && mo.Mask.Has(layer)
 }
 
 func (mo MapObject) GetPosition() Vector {
@@ -447,32 +545,49 @@ func (mo MapObject) GetCollisionArea() CollisionArea {
 }
 
 func (mo MapObject) GetCollisionBox() CollisionBox {
-	return mo.CollisionArea.ToCollisionBox(mo.Position)
+	box := mo.CollisionArea.ToCollisionBox(mo.Position)
+	box.IsRigid = !mo.IsTrigger
+	return box
 }
 
 func (mo MapObject) OnCollision(CollidableObject) {
 	return
 }
 
-func __read_map_objects() []MapObject {
-	b, err := os.ReadFile("map.json")
+func (mo MapObject) ToBytes() []byte {
+	return MarshalWire(mo)
+}
+
+func (mo *MapObject) FillFromBytes(reader io.Reader) error {
+	return UnmarshalWire(reader, mo)
+}
+
+func (mo *MapObject) validateWire() error {
+	if !mo.Action.IsValid() {
+		return fmt.Errorf("%w: map object has trigger action %d", ErrMalformedMessage, mo.Action)
+	}
+	return nil
+}
+
+// LoadMapObjects reads map created by the map editor and surrounds it with
+// invisible borders of the field.
+func LoadMapObjects(path string) ([]MapObject, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	var mos []MapObject
 	err = json.Unmarshal(b, &mos)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Map invisible borders
 	mos = append(mos,
-		MapObject{Position: Vector{X: -1, Y: -1}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: 1}},                    // Bottom
-		MapObject{Position: Vector{X: -1, Y: FieldMaxY}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: FieldMaxY + 2}}, // Top
-		MapObject{Position: Vector{X: -1, Y: -1}, CollisionArea: CollisionArea{X: 1, Y: FieldMaxY + 2}},                    // Left
-		MapObject{Position: Vector{X: FieldMaxX, Y: -1}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: FieldMaxY + 2}}, // Right
+		MapObject{Position: Vector{X: -1, Y: -1}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: 1}, Mask: CL_ALL},                    // Bottom
+		MapObject{Position: Vector{X: -1, Y: FieldMaxY}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: FieldMaxY + 2}, Mask: CL_ALL}, // Top
+		MapObject{Position: Vector{X: -1, Y: -1}, CollisionArea: CollisionArea{X: 1, Y: FieldMaxY + 2}, Mask: CL_ALL},                    // Left
+		MapObject{Position: Vector{X: FieldMaxX, Y: -1}, CollisionArea: CollisionArea{X: FieldMaxX + 2, Y: FieldMaxY + 2}, Mask: CL_ALL}, // Right
 	)
-	return mos
+	return mos, nil
 }
-
-var MapObjects = __read_map_objects()
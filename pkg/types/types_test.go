@@ -0,0 +1,105 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Entity counts around the old one byte limit and well above it.
+var entityCounts = []int{0, 1, 255, 256, 4097, MaxEntityCount}
+
+func makePlayers(count int) PlayerMap {
+	players := PlayerMap{}
+	for i := range count {
+		id := ObjectID(i)
+		players[id] = &Player{
+			ID:            id,
+			Position:      Vector{X: float64(i%80) + 0.5, Y: -float64(i % 30)},
+			Speed:         Vector{X: -12.25, Y: 3},
+			IsAirborn:     i%2 == 0,
+			ViewDirection: D_LEFT,
+			HP:            uint32(i % 6),
+			LastInput:     uint32(i * 3),
+		}
+	}
+	return players
+}
+
+func makeProjectiles(count int) ProjectileMap {
+	projectiles := ProjectileMap{}
+	for i := range count {
+		id := ObjectID(i)
+		projectiles[id] = &Projectile{
+			ID:       id,
+			Rune:     '•',
+			Position: Vector{X: float64(i % 80), Y: float64(i%30) + 0.25},
+			Speed:    Vector{X: 50, Y: -6.5},
+		}
+	}
+	return projectiles
+}
+
+func TestPlayerMapRoundTrip(t *testing.T) {
+	for _, count := range entityCounts {
+		players := makePlayers(count)
+		decoded := PlayerMap{}
+		err := decoded.FillFromBytes(bytes.NewReader(players.ToBytes()))
+		if err != nil {
+			t.Fatalf("%d players: %v", count, err)
+		}
+		if !reflect.DeepEqual(decoded, players) {
+			t.Errorf("%d players: decoded map differs", count)
+		}
+	}
+}
+
+func TestProjectileMapRoundTrip(t *testing.T) {
+	for _, count := range entityCounts {
+		projectiles := makeProjectiles(count)
+		decoded := ProjectileMap{}
+		err := decoded.FillFromBytes(bytes.NewReader(projectiles.ToBytes()))
+		if err != nil {
+			t.Fatalf("%d projectiles: %v", count, err)
+		}
+		if !reflect.DeepEqual(decoded, projectiles) {
+			t.Errorf("%d projectiles: decoded map differs", count)
+		}
+	}
+}
+
+func TestGameStateRoundTrip(t *testing.T) {
+	for _, count := range entityCounts {
+		state := GameState{
+			Players:     makePlayers(count),
+			Projectiles: makeProjectiles(count),
+			TickNumber:  GameTick(count + 1),
+		}
+		decoded, err := GameStateFromBytes(bytes.NewReader(state.ToBytes()))
+		if err != nil {
+			t.Fatalf("%d entities: %v", count, err)
+		}
+		if !reflect.DeepEqual(decoded, state) {
+			t.Errorf("%d entities: decoded state differs", count)
+		}
+	}
+}
+
+func TestEntityCountOverLimit(t *testing.T) {
+	data := binary.BigEndian.AppendUint32(nil, MaxEntityCount+1)
+
+	_, err := GameStateFromBytes(bytes.NewReader(data))
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("game state: got %v, want ErrMalformedMessage", err)
+	}
+	err = PlayerMap{}.FillFromBytes(bytes.NewReader(data))
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("players: got %v, want ErrMalformedMessage", err)
+	}
+	err = ProjectileMap{}.FillFromBytes(bytes.NewReader(data))
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("projectiles: got %v, want ErrMalformedMessage", err)
+	}
+}
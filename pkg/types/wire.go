@@ -0,0 +1,306 @@
+package types
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Wire serialization is driven by `wire` struct tags:
+//
+//	ID       ObjectID `wire:"1"`
+//	Position Vector   `wire:"3,fixed"`
+//
+// The number is the position of the field in the encoded struct, fields
+// without a tag are not sent. Encoding is picked from the Go type:
+//
+//   - bool and unsigned/signed integers are big endian of their own size
+//   - float64 is sent as IEEE 754 bits, or as a signed fixed point int32 with
+//     the "fixed" option (see FixedPointScale); the option also applies to
+//     all floats of a nested struct
+//   - string is a uint16 length followed by the bytes
+//   - slices and maps are a uint32 count followed by the elements, map
+//     values must implement GetID() which gives back the map key and are
+//     sent in increasing order of it
+//   - pointers are encoded as the value they point to
+//
+// A decoded struct that implements wireValidator is checked right after it
+// is read.
+
+const maxStringLength = 1024
+
+type wireValidator interface {
+	validateWire() error
+}
+
+type identifiable interface {
+	GetID() ObjectID
+}
+
+type wireField struct {
+	index int
+	order int
+	fixed bool
+}
+
+var wirePlans sync.Map // reflect.Type -> []wireField
+
+func wirePlan(t reflect.Type) []wireField {
+	if plan, ok := wirePlans.Load(t); ok {
+		return plan.([]wireField)
+	}
+
+	plan := []wireField{}
+	for i := range t.NumField() {
+		tag, ok := t.Field(i).Tag.Lookup("wire")
+		if !ok {
+			continue
+		}
+		options := strings.Split(tag, ",")
+		order, err := strconv.Atoi(options[0])
+		if err != nil {
+			panic(fmt.Sprintf("wire: bad order %q of %s.%s", options[0], t.Name(), t.Field(i).Name))
+		}
+		plan = append(plan, wireField{
+			index: i,
+			order: order,
+			fixed: slices.Contains(options[1:], "fixed"),
+		})
+	}
+	slices.SortFunc(plan, func(a, b wireField) int { return cmp.Compare(a.order, b.order) })
+	for i := 1; i < len(plan); i++ {
+		if plan[i].order == plan[i-1].order {
+			panic(fmt.Sprintf("wire: duplicate order %d in %s", plan[i].order, t.Name()))
+		}
+	}
+
+	wirePlans.Store(t, plan)
+	return plan
+}
+
+// MarshalWire encodes the value according to its wire tags. It panics on
+// types that can't be encoded, that is a programming error.
+func MarshalWire(v any) []byte {
+	return appendWire(nil, reflect.ValueOf(v), false)
+}
+
+func appendWire(b []byte, v reflect.Value, fixed bool) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Uint8:
+		return append(b, byte(v.Uint()))
+	case reflect.Uint16:
+		return binary.BigEndian.AppendUint16(b, uint16(v.Uint()))
+	case reflect.Uint32:
+		return binary.BigEndian.AppendUint32(b, uint32(v.Uint()))
+	case reflect.Uint64:
+		return binary.BigEndian.AppendUint64(b, v.Uint())
+	case reflect.Int32:
+		return binary.BigEndian.AppendUint32(b, uint32(int32(v.Int())))
+	case reflect.Int64:
+		return binary.BigEndian.AppendUint64(b, uint64(v.Int()))
+	case reflect.Float64:
+		if fixed {
+			return binary.BigEndian.AppendUint32(b, uint32(toFixedPoint(v.Float())))
+		}
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.String:
+		s := v.String()
+		if len(s) > maxStringLength {
+			s = s[:maxStringLength]
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+		return append(b, s...)
+	case reflect.Slice:
+		b = append(b, entityCountToBytes(v.Len())...)
+		for i := range v.Len() {
+			b = appendWire(b, v.Index(i), fixed)
+		}
+		return b
+	case reflect.Map:
+		b = append(b, entityCountToBytes(v.Len())...)
+		keys := v.MapKeys()
+		// Stable order keeps equal states byte to byte equal
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Uint(), b.Uint()) })
+		for _, key := range keys {
+			b = appendWire(b, v.MapIndex(key), fixed)
+		}
+		return b
+	case reflect.Pointer:
+		return appendWire(b, v.Elem(), fixed)
+	case reflect.Struct:
+		for _, f := range wirePlan(v.Type()) {
+			b = appendWire(b, v.Field(f.index), fixed || f.fixed)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("wire: unsupported type %s", v.Type()))
+}
+
+// UnmarshalWire decodes the value encoded by MarshalWire into v, which must
+// be a pointer.
+func UnmarshalWire(reader io.Reader, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		panic(fmt.Sprintf("wire: can't decode into %T", v))
+	}
+	return readWire(reader, rv.Elem(), false)
+}
+
+func readWireBytes(reader io.Reader, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(reader, data)
+	return data, err
+}
+
+func readWire(reader io.Reader, v reflect.Value, fixed bool) error {
+	switch v.Kind() {
+	case reflect.Bool, reflect.Uint8:
+		data, err := readWireBytes(reader, 1)
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Bool {
+			if data[0] > 1 {
+				return fmt.Errorf("%w: bool value %d", ErrMalformedMessage, data[0])
+			}
+			v.SetBool(data[0] == 1)
+		} else {
+			v.SetUint(uint64(data[0]))
+		}
+	case reflect.Uint16:
+		data, err := readWireBytes(reader, 2)
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(binary.BigEndian.Uint16(data)))
+	case reflect.Uint32:
+		data, err := readWireBytes(reader, 4)
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(binary.BigEndian.Uint32(data)))
+	case reflect.Uint64:
+		data, err := readWireBytes(reader, 8)
+		if err != nil {
+			return err
+		}
+		v.SetUint(binary.BigEndian.Uint64(data))
+	case reflect.Int32:
+		data, err := readWireBytes(reader, 4)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(int32(binary.BigEndian.Uint32(data))))
+	case reflect.Int64:
+		data, err := readWireBytes(reader, 8)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(binary.BigEndian.Uint64(data)))
+	case reflect.Float64:
+		if fixed {
+			data, err := readWireBytes(reader, 4)
+			if err != nil {
+				return err
+			}
+			v.SetFloat(fromFixedPoint(int32(binary.BigEndian.Uint32(data))))
+			return nil
+		}
+		data, err := readWireBytes(reader, 8)
+		if err != nil {
+			return err
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(data))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("%w: non-finite float", ErrMalformedMessage)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		data, err := readWireBytes(reader, 2)
+		if err != nil {
+			return err
+		}
+		strLen := int(binary.BigEndian.Uint16(data))
+		if strLen > maxStringLength {
+			return fmt.Errorf("%w: string length %d exceeds limit %d", ErrMalformedMessage, strLen, maxStringLength)
+		}
+		data, err = readWireBytes(reader, strLen)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(data))
+	case reflect.Slice:
+		count, err := entityCountFromBytes(reader)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), 0, min(count, 64))
+		for range count {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			err := readWire(reader, elem, fixed)
+			if err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		v.Set(slice)
+	case reflect.Map:
+		count, err := entityCountFromBytes(reader)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		lastID := ObjectID(0)
+		for i := range count {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			err := readWire(reader, elem, fixed)
+			if err != nil {
+				return err
+			}
+			id, ok := elem.Interface().(identifiable)
+			if !ok {
+				panic(fmt.Sprintf("wire: map value %s has no GetID", elem.Type()))
+			}
+			key := reflect.ValueOf(id.GetID()).Convert(v.Type().Key())
+			// Values come sorted by ID, a repeated ID would silently
+			// replace an entity
+			if i > 0 && id.GetID() <= lastID {
+				return fmt.Errorf("%w: map ID %d after %d", ErrMalformedMessage, id.GetID(), lastID)
+			}
+			lastID = id.GetID()
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return readWire(reader, v.Elem(), fixed)
+	case reflect.Struct:
+		for _, f := range wirePlan(v.Type()) {
+			err := readWire(reader, v.Field(f.index), fixed || f.fixed)
+			if err != nil {
+				return err
+			}
+		}
+		if validator, ok := v.Addr().Interface().(wireValidator); ok {
+			return validator.validateWire()
+		}
+	default:
+		panic(fmt.Sprintf("wire: unsupported type %s", v.Type()))
+	}
+	return nil
+}